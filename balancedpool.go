@@ -0,0 +1,564 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Balance is a strategy of picking a backend in BalancedPool.
+type Balance int
+
+const (
+	// RoundRobin picks backends in turn.
+	RoundRobin Balance = iota
+	// LeastInUse picks the backend with the fewest acquired connections.
+	LeastInUse
+	// PowerOfTwo picks the less loaded of two random backends.
+	PowerOfTwo
+	// ConsistentHash picks the backend by the key passed to AcquireKey,
+	// only keys of a removed or ejected backend move to other backends.
+	ConsistentHash
+)
+
+var (
+	// ErrNoBackends is returned when all backends are ejected or removed.
+	ErrNoBackends = errors.New("netx: no healthy backends")
+
+	// ErrPoolClosed is returned by Acquire after BalancedPool.Close.
+	ErrPoolClosed = errors.New("netx: pool closed")
+)
+
+// BalancedPoolConfig is a config for BalancedPool.
+type BalancedPoolConfig struct {
+	// Balance is the strategy of picking a backend (default RoundRobin).
+	Balance Balance
+
+	// Dial dials a backend (default net.Dialer with "tcp" network).
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+
+	// MaxIdle is the max number of idle connections kept per backend (default 4).
+	MaxIdle int
+
+	// MaxFails is the number of consecutive dial or i/o errors
+	// after which the backend is ejected (default 3).
+	MaxFails int
+
+	// EjectTime is how long an ejected backend is skipped (default 10s).
+	// With health checks an ejected backend is back only after a successful probe.
+	EjectTime time.Duration
+
+	// HealthCheckInterval enables active health checks of all backends, 0 disables them.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout is the timeout of a single probe (default 1s).
+	HealthCheckTimeout time.Duration
+
+	// HealthCheck probes the backend (default dials the addr and closes the connection).
+	HealthCheck func(ctx context.Context, addr string) error
+
+	// VirtualNodes is the number of points of a backend on the ConsistentHash ring (default 100).
+	VirtualNodes int
+}
+
+// BalancedPool is a pool of connections to a dynamic set of backends.
+//
+// Backends that fail to dial or fail i/o are ejected passively,
+// optional health checks eject and return them actively.
+type BalancedPool struct {
+	cfg BalancedPoolConfig
+
+	mu       sync.Mutex
+	backends []*backend
+	byAddr   map[string]*backend
+	ring     []hashPoint
+	next     uint64
+	closed   bool
+
+	doneCh chan struct{}
+}
+
+type backend struct {
+	addr  string
+	stats *Stats
+	idle  []net.Conn // guarded by pool mu
+
+	inUse        int64 // accessed atomically
+	fails        int64 // accessed atomically
+	ejectedUntil int64 // unix nano, accessed atomically
+	removed      int32 // accessed atomically
+}
+
+type hashPoint struct {
+	hash    uint64
+	backend *backend
+}
+
+// BackendStatus is a snapshot of a BalancedPool backend.
+type BackendStatus struct {
+	Addr    string
+	InUse   int
+	Idle    int
+	Fails   int
+	Ejected bool
+}
+
+// NewBalancedPool returns new pool over the backends.
+// Health checks, if enabled, run until ctx is done or the pool is closed.
+func NewBalancedPool(ctx context.Context, cfg BalancedPoolConfig, addrs ...string) *BalancedPool {
+	if cfg.Dial == nil {
+		var d net.Dialer
+		cfg.Dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	if cfg.MaxIdle <= 0 {
+		cfg.MaxIdle = 4
+	}
+	if cfg.MaxFails <= 0 {
+		cfg.MaxFails = 3
+	}
+	if cfg.EjectTime <= 0 {
+		cfg.EjectTime = 10 * time.Second
+	}
+	if cfg.HealthCheckTimeout <= 0 {
+		cfg.HealthCheckTimeout = time.Second
+	}
+	if cfg.HealthCheck == nil {
+		dial := cfg.Dial
+		cfg.HealthCheck = func(ctx context.Context, addr string) error {
+			conn, err := dial(ctx, addr)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+	}
+	if cfg.VirtualNodes <= 0 {
+		cfg.VirtualNodes = 100
+	}
+
+	p := &BalancedPool{
+		cfg:    cfg,
+		byAddr: map[string]*backend{},
+		doneCh: make(chan struct{}),
+	}
+	for _, addr := range addrs {
+		p.Add(addr)
+	}
+
+	if cfg.HealthCheckInterval > 0 {
+		go p.healthChecks(ctx)
+	}
+	return p
+}
+
+// Add adds the backend, adding an existing backend does nothing.
+func (p *BalancedPool) Add(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.byAddr[addr]; ok {
+		return
+	}
+	b := &backend{addr: addr, stats: &Stats{}}
+	p.byAddr[addr] = b
+	p.backends = append(p.backends, b)
+	p.rebuildRing()
+}
+
+// Remove removes the backend and closes its idle connections,
+// acquired connections are closed on Release.
+func (p *BalancedPool) Remove(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b, ok := p.byAddr[addr]
+	if !ok {
+		return
+	}
+	atomic.StoreInt32(&b.removed, 1)
+	delete(p.byAddr, addr)
+	for i, bb := range p.backends {
+		if bb == b {
+			p.backends = append(p.backends[:i:i], p.backends[i+1:]...)
+			break
+		}
+	}
+	p.closeIdle(b)
+	p.rebuildRing()
+}
+
+// rebuildRing rebuilds the ConsistentHash ring, p.mu must be held.
+func (p *BalancedPool) rebuildRing() {
+	if p.cfg.Balance != ConsistentHash {
+		return
+	}
+	ring := make([]hashPoint, 0, len(p.backends)*p.cfg.VirtualNodes)
+	for _, b := range p.backends {
+		for i := 0; i < p.cfg.VirtualNodes; i++ {
+			ring = append(ring, hashPoint{hash: hashKey(b.addr + "#" + strconv.Itoa(i)), backend: b})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	p.ring = ring
+}
+
+// Backends returns the status of every backend.
+func (p *BalancedPool) Backends() []BackendStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	res := make([]BackendStatus, 0, len(p.backends))
+	for _, b := range p.backends {
+		res = append(res, BackendStatus{
+			Addr:    b.addr,
+			InUse:   int(atomic.LoadInt64(&b.inUse)),
+			Idle:    len(b.idle),
+			Fails:   int(atomic.LoadInt64(&b.fails)),
+			Ejected: b.ejected(now),
+		})
+	}
+	return res
+}
+
+// Stats of the backend connections, nil if there is no such backend.
+func (p *BalancedPool) Stats(addr string) *Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if b, ok := p.byAddr[addr]; ok {
+		return b.stats
+	}
+	return nil
+}
+
+// Acquire returns a connection to a backend picked by the Balance strategy.
+// With ConsistentHash it's the same as AcquireKey with an empty key.
+func (p *BalancedPool) Acquire(ctx context.Context) (*PooledConn, error) {
+	return p.AcquireKey(ctx, "")
+}
+
+// AcquireKey returns a connection to a backend, key is used by ConsistentHash.
+// A backend that fails to dial is counted as failed and the next one is tried.
+func (p *BalancedPool) AcquireKey(ctx context.Context, key string) (*PooledConn, error) {
+	var tried []*backend
+	lastErr := ErrNoBackends
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		b := p.pick(key, tried)
+		if b == nil {
+			p.mu.Unlock()
+			return nil, lastErr
+		}
+		atomic.AddInt64(&b.inUse, 1)
+		var conn net.Conn
+		if n := len(b.idle); n > 0 {
+			conn = b.idle[n-1]
+			b.idle = b.idle[:n-1]
+		}
+		p.mu.Unlock()
+
+		if conn != nil {
+			return p.pooledConn(conn, b), nil
+		}
+
+		conn, err := p.cfg.Dial(ctx, b.addr)
+		if err == nil {
+			atomic.StoreInt64(&b.fails, 0)
+			return p.pooledConn(conn, b), nil
+		}
+
+		atomic.AddInt64(&b.inUse, -1)
+		if ctx.Err() == nil {
+			p.fail(b)
+		}
+		lastErr = err
+		tried = append(tried, b)
+	}
+}
+
+func (p *BalancedPool) pooledConn(conn net.Conn, b *backend) *PooledConn {
+	return &PooledConn{Conn: conn, pool: p, backend: b, shard: pickShard()}
+}
+
+// pick returns a healthy backend not in tried, p.mu must be held.
+func (p *BalancedPool) pick(key string, tried []*backend) *backend {
+	now := time.Now()
+	usable := func(b *backend) bool {
+		if b.ejected(now) {
+			return false
+		}
+		for _, t := range tried {
+			if t == b {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch p.cfg.Balance {
+	case ConsistentHash:
+		if len(p.ring) == 0 {
+			return nil
+		}
+		h := hashKey(key)
+		start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+		for i := 0; i < len(p.ring); i++ {
+			if b := p.ring[(start+i)%len(p.ring)].backend; usable(b) {
+				return b
+			}
+		}
+		return nil
+
+	case LeastInUse:
+		var best *backend
+		for _, b := range p.backends {
+			if usable(b) && (best == nil || atomic.LoadInt64(&b.inUse) < atomic.LoadInt64(&best.inUse)) {
+				best = b
+			}
+		}
+		return best
+
+	case PowerOfTwo:
+		candidates := make([]*backend, 0, len(p.backends))
+		for _, b := range p.backends {
+			if usable(b) {
+				candidates = append(candidates, b)
+			}
+		}
+		switch len(candidates) {
+		case 0:
+			return nil
+		case 1:
+			return candidates[0]
+		}
+		i := rand.Intn(len(candidates))
+		j := rand.Intn(len(candidates) - 1)
+		if j >= i {
+			j++
+		}
+		if atomic.LoadInt64(&candidates[j].inUse) < atomic.LoadInt64(&candidates[i].inUse) {
+			return candidates[j]
+		}
+		return candidates[i]
+
+	default:
+		for i := 0; i < len(p.backends); i++ {
+			b := p.backends[p.next%uint64(len(p.backends))]
+			p.next++
+			if usable(b) {
+				return b
+			}
+		}
+		return nil
+	}
+}
+
+// Release returns the connection to the pool, it's a no-op after Release or Close.
+// Broken connections and connections of removed backends are closed.
+func (p *BalancedPool) Release(conn *PooledConn) {
+	p.release(conn, false)
+}
+
+func (p *BalancedPool) release(conn *PooledConn, discard bool) error {
+	if !atomic.CompareAndSwapInt32(&conn.released, 0, 1) {
+		return nil
+	}
+	b := conn.backend
+	atomic.AddInt64(&b.inUse, -1)
+
+	p.mu.Lock()
+	keep := !discard && !p.closed && atomic.LoadInt32(&conn.broken) == 0 &&
+		atomic.LoadInt32(&b.removed) == 0 && !b.ejected(time.Now()) && len(b.idle) < p.cfg.MaxIdle
+	if keep {
+		b.idle = append(b.idle, conn.Conn)
+	}
+	p.mu.Unlock()
+
+	if !keep {
+		return conn.close()
+	}
+	return nil
+}
+
+// Close closes idle connections and stops health checks,
+// acquired connections are closed on Release.
+func (p *BalancedPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.doneCh)
+	for _, b := range p.backends {
+		p.closeIdle(b)
+	}
+	return nil
+}
+
+// fail counts a failure of the backend and ejects it after MaxFails in a row.
+func (p *BalancedPool) fail(b *backend) {
+	if atomic.AddInt64(&b.fails, 1) < int64(p.cfg.MaxFails) {
+		return
+	}
+	p.eject(b, time.Now().Add(p.cfg.EjectTime))
+}
+
+func (p *BalancedPool) eject(b *backend, until time.Time) {
+	if p.cfg.HealthCheckInterval > 0 {
+		until = time.Unix(0, math.MaxInt64) // until a successful probe
+	}
+	atomic.StoreInt64(&b.ejectedUntil, until.UnixNano())
+
+	p.mu.Lock()
+	p.closeIdle(b)
+	p.mu.Unlock()
+}
+
+// closeIdle closes idle connections of the backend, p.mu must be held.
+func (p *BalancedPool) closeIdle(b *backend) {
+	for _, conn := range b.idle {
+		conn.Close()
+		b.stats.connsInc()
+		b.stats.finClosesInc()
+	}
+	b.idle = nil
+}
+
+func (p *BalancedPool) healthChecks(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.doneCh:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		backends := append([]*backend(nil), p.backends...)
+		p.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, b := range backends {
+			wg.Add(1)
+			go func(b *backend) {
+				defer wg.Done()
+				p.probe(ctx, b)
+			}(b)
+		}
+		wg.Wait()
+	}
+}
+
+func (p *BalancedPool) probe(ctx context.Context, b *backend) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.HealthCheckTimeout)
+	defer cancel()
+
+	if err := p.cfg.HealthCheck(ctx, b.addr); err != nil {
+		p.eject(b, time.Time{})
+		return
+	}
+	atomic.StoreInt64(&b.fails, 0)
+	atomic.StoreInt64(&b.ejectedUntil, 0)
+}
+
+func (b *backend) ejected(now time.Time) bool {
+	until := atomic.LoadInt64(&b.ejectedUntil)
+	return until != 0 && now.UnixNano() < until
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// PooledConn is a connection acquired from BalancedPool, return it with Release
+// or discard it with Close.
+//
+// I/O errors mark the connection as broken, so it's closed on Release,
+// and count as failures of its backend.
+type PooledConn struct {
+	net.Conn
+	pool     *BalancedPool
+	backend  *backend
+	shard    uint32 // of backend stats io counters
+	broken   int32  // accessed atomically
+	released int32  // accessed atomically
+}
+
+// Backend returns the addr of the connection backend.
+func (c *PooledConn) Backend() string {
+	return c.backend.addr
+}
+
+// Read reads data from the connection.
+func (c *PooledConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.backend.stats.readDone(c.shard, int64(n), err)
+	c.done(err)
+	return n, err
+}
+
+// Write writes data to the connection.
+func (c *PooledConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.backend.stats.writeDone(c.shard, int64(n), err)
+	c.done(err)
+	return n, err
+}
+
+func (c *PooledConn) done(err error) {
+	switch {
+	case err == nil:
+		atomic.StoreInt64(&c.backend.fails, 0)
+	case err == io.EOF || errors.Is(err, os.ErrDeadlineExceeded):
+		atomic.StoreInt32(&c.broken, 1)
+	default:
+		atomic.StoreInt32(&c.broken, 1)
+		c.pool.fail(c.backend)
+	}
+}
+
+// Close releases the connection without returning it to the pool,
+// it's a no-op after Release or Close.
+func (c *PooledConn) Close() error {
+	return c.pool.release(c, true)
+}
+
+func (c *PooledConn) close() error {
+	err := c.Conn.Close()
+	c.backend.stats.connsInc()
+	if err != nil {
+		c.backend.stats.closeErrorsInc()
+	} else {
+		c.backend.stats.finClosesInc()
+	}
+	return err
+}
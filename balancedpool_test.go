@@ -0,0 +1,216 @@
+package netx
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBalancedPool_RoundRobin(t *testing.T) {
+	addr1, addr2 := newEchoServer(t), newEchoServer(t)
+	p := NewBalancedPool(context.Background(), BalancedPoolConfig{}, addr1, addr2)
+	defer p.Close()
+
+	got := map[string]int{}
+	for i := 0; i < 4; i++ {
+		conn, err := p.Acquire(context.Background())
+		failIfErr(t, err, "cannot acquire: %s", err)
+		got[conn.Backend()]++
+		p.Release(conn)
+	}
+	if got[addr1] != 2 || got[addr2] != 2 {
+		t.Fatalf("want 2 and 2 conns, got %v", got)
+	}
+}
+
+func TestBalancedPool_Eject(t *testing.T) {
+	addr := newEchoServer(t)
+	down := closedAddr(t)
+	p := NewBalancedPool(context.Background(), BalancedPoolConfig{MaxFails: 1}, down, addr)
+	defer p.Close()
+
+	for i := 0; i < 4; i++ {
+		conn, err := p.Acquire(context.Background())
+		failIfErr(t, err, "cannot acquire: %s", err)
+		if conn.Backend() != addr {
+			t.Fatalf("want %s, got %s", addr, conn.Backend())
+		}
+		p.Release(conn)
+	}
+
+	for _, st := range p.Backends() {
+		if st.Ejected != (st.Addr == down) {
+			t.Fatalf("unexpected status %+v", st)
+		}
+	}
+
+	p.Remove(addr)
+	if _, err := p.Acquire(context.Background()); err != ErrNoBackends {
+		t.Fatalf("want ErrNoBackends, got %v", err)
+	}
+}
+
+func TestBalancedPool_HealthCheck(t *testing.T) {
+	addr := newEchoServer(t)
+	cfg := BalancedPoolConfig{
+		MaxFails:            1,
+		HealthCheckInterval: 10 * time.Millisecond,
+	}
+	p := NewBalancedPool(context.Background(), cfg, addr)
+	defer p.Close()
+
+	p.fail(p.byAddr[addr])
+	if !p.Backends()[0].Ejected {
+		t.Fatal("want ejected backend")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Backends()[0].Ejected {
+		if time.Now().After(deadline) {
+			t.Fatal("backend is not returned by health check")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBalancedPool_ConsistentHash(t *testing.T) {
+	addrs := []string{newEchoServer(t), newEchoServer(t), newEchoServer(t)}
+	p := NewBalancedPool(context.Background(), BalancedPoolConfig{Balance: ConsistentHash}, addrs...)
+	defer p.Close()
+
+	backendOf := func(key string) string {
+		conn, err := p.AcquireKey(context.Background(), key)
+		failIfErr(t, err, "cannot acquire: %s", err)
+		defer p.Release(conn)
+		return conn.Backend()
+	}
+
+	before := map[string]string{}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		before[key] = backendOf(key)
+		if again := backendOf(key); again != before[key] {
+			t.Fatalf("key %q moved from %s to %s", key, before[key], again)
+		}
+	}
+
+	p.Remove(addrs[0])
+	for key, addr := range before {
+		if got := backendOf(key); addr != addrs[0] && got != addr {
+			t.Fatalf("key %q moved from %s to %s", key, addr, got)
+		}
+	}
+}
+
+func TestBalancedPool_Stats(t *testing.T) {
+	addr := newEchoServer(t)
+	p := NewBalancedPool(context.Background(), BalancedPoolConfig{Balance: LeastInUse}, addr)
+	defer p.Close()
+
+	conn, err := p.Acquire(context.Background())
+	failIfErr(t, err, "cannot acquire: %s", err)
+
+	_, err = conn.Write([]byte("ping"))
+	failIfErr(t, err, "cannot write: %s", err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	failIfErr(t, err, "cannot read: %s", err)
+	p.Release(conn)
+
+	stats := p.Stats(addr)
+	if stats.WrittenBytes() != 4 || stats.ReadBytes() != 4 {
+		t.Fatalf("want 4 written and read bytes, got %d and %d", stats.WrittenBytes(), stats.ReadBytes())
+	}
+	if st := p.Backends()[0]; st.Idle != 1 || st.InUse != 0 {
+		t.Fatalf("want 1 idle conn, got %+v", st)
+	}
+}
+
+func TestBalancedPool_CloseConn(t *testing.T) {
+	addr := newEchoServer(t)
+	p := NewBalancedPool(context.Background(), BalancedPoolConfig{}, addr)
+	defer p.Close()
+
+	conn, err := p.Acquire(context.Background())
+	failIfErr(t, err, "cannot acquire: %s", err)
+	failIfErr(t, conn.Close(), "cannot close")
+	p.Release(conn)
+
+	if st := p.Backends()[0]; st.Idle != 0 || st.InUse != 0 {
+		t.Fatalf("want no conns, got %+v", st)
+	}
+
+	conn, err = p.Acquire(context.Background())
+	failIfErr(t, err, "cannot acquire: %s", err)
+	p.Release(conn)
+	p.Release(conn)
+	if st := p.Backends()[0]; st.Idle != 1 || st.InUse != 0 {
+		t.Fatalf("want 1 idle conn, got %+v", st)
+	}
+}
+
+func TestBalancedPool_FullDuplex(t *testing.T) {
+	addr := newEchoServer(t)
+	p := NewBalancedPool(context.Background(), BalancedPoolConfig{}, addr)
+	defer p.Close()
+
+	conn, err := p.Acquire(context.Background())
+	failIfErr(t, err, "cannot acquire: %s", err)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(conn, make([]byte, 4))
+		done <- err
+	}()
+	_, err = conn.Write([]byte("ping"))
+	failIfErr(t, err, "cannot write: %s", err)
+	err = <-done
+	failIfErr(t, err, "cannot read: %s", err)
+
+	// both sides fail at once and mark the conn as broken
+	conn.SetDeadline(time.Now())
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	conn.Write([]byte("x"))
+	<-done
+	p.Release(conn)
+	if st := p.Backends()[0]; st.Idle != 0 {
+		t.Fatalf("want broken conn closed, got %+v", st)
+	}
+}
+
+// newEchoServer returns the addr of a local echo server closed with the test.
+func newEchoServer(tb testing.TB) string {
+	tb.Helper()
+
+	ln, err := NewTCPListener(context.Background(), "tcp4", "127.0.0.1:0", TCPListenerConfig{})
+	failIfErr(tb, err, "cannot create listener: %s", err)
+	tb.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// closedAddr returns a local addr nobody listens on.
+func closedAddr(tb testing.TB) string {
+	tb.Helper()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	failIfErr(tb, err, "cannot listen: %s", err)
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
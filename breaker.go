@@ -0,0 +1,315 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BreakerState is a state of Breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects all requests.
+	BreakerOpen
+	// BreakerHalfOpen lets a few trial requests through.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "BreakerState(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// ErrBreakerOpen is matched with errors.Is by the errors of an open circuit.
+var ErrBreakerOpen = errors.New("netx: circuit breaker is open")
+
+// BreakerOpenError is returned by Breaker.Allow while the circuit is open.
+type BreakerOpenError struct {
+	// RetryAfter is the time left until the circuit is half-open.
+	RetryAfter time.Duration
+}
+
+func (e *BreakerOpenError) Error() string {
+	return ErrBreakerOpen.Error() + ", retry after " + e.RetryAfter.String()
+}
+
+func (e *BreakerOpenError) Is(target error) bool {
+	return target == ErrBreakerOpen
+}
+
+// BreakerConfig is a config for Breaker.
+type BreakerConfig struct {
+	// ConsecutiveFailures opens the circuit after this number of failures in a row (default 5).
+	ConsecutiveFailures int
+
+	// FailureRate opens the circuit when the share of failures in Window
+	// reaches it, from 0 to 1, 0 disables it.
+	FailureRate float64
+
+	// MinRequests is the minimum number of requests in Window
+	// to apply FailureRate (default 10).
+	MinRequests int
+
+	// Window is the sliding window of FailureRate (default 10s).
+	Window time.Duration
+
+	// OpenTimeout is how long the circuit stays open before it's half-open (default 5s).
+	OpenTimeout time.Duration
+
+	// HalfOpenRequests is the number of trial requests in the half-open state,
+	// the circuit is closed when all of them succeed (default 1).
+	HalfOpenRequests int
+
+	// OnStateChange is called on every state transition.
+	OnStateChange func(from, to BreakerState)
+}
+
+// breakerBuckets is the number of buckets of the sliding window.
+const breakerBuckets = 10
+
+// Breaker is a circuit breaker.
+//
+// Every request must call Allow before and Record with its token after,
+// Record is skipped only when Allow returns an error.
+type Breaker struct {
+	cfg BreakerConfig
+
+	mu           sync.Mutex
+	state        BreakerState
+	gen          uint64 // incremented on every state change
+	consecutive  int
+	openedAt     time.Time
+	trials       int // allowed in the half-open state
+	trialsPassed int
+	buckets      [breakerBuckets]breakerBucket
+
+	opens     atomicCounter
+	halfOpens atomicCounter
+	closes    atomicCounter
+	rejects   atomicCounter
+	successes atomicCounter
+	failures  atomicCounter
+}
+
+// BreakerToken ties the result of a request to the state of Breaker it was allowed in.
+type BreakerToken struct {
+	gen uint64
+}
+
+type breakerBucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+// NewBreaker returns new closed Breaker.
+func NewBreaker(cfg BreakerConfig) *Breaker {
+	if cfg.ConsecutiveFailures <= 0 {
+		cfg.ConsecutiveFailures = 5
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.Window < breakerBuckets {
+		// a bucket is at least 1ns
+		cfg.Window = breakerBuckets
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 5 * time.Second
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	return &Breaker{cfg: cfg}
+}
+
+// State returns the current state.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow returns *BreakerOpenError if the request must fail fast,
+// otherwise the token to pass to Record.
+func (b *Breaker) Allow() (BreakerToken, error) {
+	b.mu.Lock()
+	now := time.Now()
+	var change func()
+	if b.state == BreakerOpen {
+		if wait := b.openedAt.Add(b.cfg.OpenTimeout).Sub(now); wait > 0 {
+			b.mu.Unlock()
+			atomic.AddUint64(&b.rejects.count, 1)
+			return BreakerToken{}, &BreakerOpenError{RetryAfter: wait}
+		}
+		change = b.setState(BreakerHalfOpen, now)
+	}
+	token := BreakerToken{gen: b.gen}
+
+	var err error
+	if b.state == BreakerHalfOpen {
+		if b.trials < b.cfg.HalfOpenRequests {
+			b.trials++
+		} else {
+			err = &BreakerOpenError{}
+		}
+	}
+	b.mu.Unlock()
+
+	if change != nil {
+		change()
+	}
+	if err != nil {
+		atomic.AddUint64(&b.rejects.count, 1)
+		return BreakerToken{}, err
+	}
+	return token, nil
+}
+
+// Record records the result of a request allowed with the token.
+// context.Canceled is not counted as a failure. Results of requests
+// allowed before the last state change are counted but don't change the state.
+func (b *Breaker) Record(token BreakerToken, err error) {
+	canceled := errors.Is(err, context.Canceled)
+	switch {
+	case canceled:
+	case err != nil:
+		atomic.AddUint64(&b.failures.count, 1)
+	default:
+		atomic.AddUint64(&b.successes.count, 1)
+	}
+
+	b.mu.Lock()
+	if token.gen != b.gen {
+		b.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	var change func()
+	switch b.state {
+	case BreakerClosed:
+		if canceled {
+			break
+		}
+		bucket := b.bucket(now)
+		if err != nil {
+			bucket.failures++
+			b.consecutive++
+		} else {
+			bucket.successes++
+			b.consecutive = 0
+		}
+		if err != nil && b.tripped(now) {
+			change = b.setState(BreakerOpen, now)
+		}
+
+	case BreakerHalfOpen:
+		switch {
+		case canceled:
+			b.trials--
+		case err != nil:
+			change = b.setState(BreakerOpen, now)
+		default:
+			b.trialsPassed++
+			if b.trialsPassed >= b.cfg.HalfOpenRequests {
+				change = b.setState(BreakerClosed, now)
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	if change != nil {
+		change()
+	}
+}
+
+// tripped reports whether the closed circuit must open, b.mu must be held.
+func (b *Breaker) tripped(now time.Time) bool {
+	if b.consecutive >= b.cfg.ConsecutiveFailures {
+		return true
+	}
+	if b.cfg.FailureRate <= 0 {
+		return false
+	}
+
+	var successes, failures int
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.cfg.Window {
+			successes += bucket.successes
+			failures += bucket.failures
+		}
+	}
+	total := successes + failures
+	return total >= b.cfg.MinRequests && float64(failures) >= b.cfg.FailureRate*float64(total)
+}
+
+// bucket returns the window bucket for now, b.mu must be held.
+func (b *Breaker) bucket(now time.Time) *breakerBucket {
+	width := b.cfg.Window / breakerBuckets
+	start := now.Truncate(width)
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+// setState changes the state and returns the callback to call without b.mu, b.mu must be held.
+func (b *Breaker) setState(to BreakerState, now time.Time) func() {
+	from := b.state
+	b.state = to
+	b.gen++
+	b.consecutive = 0
+	b.trials, b.trialsPassed = 0, 0
+
+	switch to {
+	case BreakerOpen:
+		b.openedAt = now
+		atomic.AddUint64(&b.opens.count, 1)
+	case BreakerHalfOpen:
+		atomic.AddUint64(&b.halfOpens.count, 1)
+	case BreakerClosed:
+		b.buckets = [breakerBuckets]breakerBucket{}
+		atomic.AddUint64(&b.closes.count, 1)
+	}
+
+	if b.cfg.OnStateChange == nil {
+		return nil
+	}
+	return func() { b.cfg.OnStateChange(from, to) }
+}
+
+// Opens is the number of transitions to the open state.
+func (b *Breaker) Opens() uint64 { return atomic.LoadUint64(&b.opens.count) }
+
+// HalfOpens is the number of transitions to the half-open state.
+func (b *Breaker) HalfOpens() uint64 { return atomic.LoadUint64(&b.halfOpens.count) }
+
+// Closes is the number of transitions to the closed state.
+func (b *Breaker) Closes() uint64 { return atomic.LoadUint64(&b.closes.count) }
+
+// Rejects is the number of requests failed fast.
+func (b *Breaker) Rejects() uint64 { return atomic.LoadUint64(&b.rejects.count) }
+
+// Successes is the number of recorded successful requests.
+func (b *Breaker) Successes() uint64 { return atomic.LoadUint64(&b.successes.count) }
+
+// Failures is the number of recorded failed requests.
+func (b *Breaker) Failures() uint64 { return atomic.LoadUint64(&b.failures.count) }
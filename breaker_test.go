@@ -0,0 +1,178 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	var changes []string
+	b := NewBreaker(BreakerConfig{
+		ConsecutiveFailures: 2,
+		OpenTimeout:         20 * time.Millisecond,
+		OnStateChange: func(from, to BreakerState) {
+			changes = append(changes, from.String()+"->"+to.String())
+		},
+	})
+
+	errFail := errors.New("fail")
+	for i := 0; i < 2; i++ {
+		token, err := b.Allow()
+		failIfErr(t, err, "closed breaker must allow")
+		b.Record(token, errFail)
+	}
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("want open, got %s", s)
+	}
+
+	_, err := b.Allow()
+	var openErr *BreakerOpenError
+	if !errors.Is(err, ErrBreakerOpen) || !errors.As(err, &openErr) || openErr.RetryAfter <= 0 {
+		t.Fatalf("want BreakerOpenError, got %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	token, err := b.Allow()
+	failIfErr(t, err, "half-open breaker must allow a trial")
+	if _, err := b.Allow(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("want only 1 trial, got %v", err)
+	}
+	b.Record(token, nil)
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("want closed, got %s", s)
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(changes) != len(want) {
+		t.Fatalf("want %v, got %v", want, changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, changes)
+		}
+	}
+	if b.Opens() != 1 || b.HalfOpens() != 1 || b.Closes() != 1 || b.Rejects() != 2 {
+		t.Fatalf("unexpected counters %d %d %d %d", b.Opens(), b.HalfOpens(), b.Closes(), b.Rejects())
+	}
+}
+
+func TestBreaker_FailureRate(t *testing.T) {
+	b := NewBreaker(BreakerConfig{
+		ConsecutiveFailures: 100,
+		FailureRate:         0.5,
+		MinRequests:         4,
+	})
+
+	errFail := errors.New("fail")
+	for _, errReq := range []error{nil, errFail, nil, context.Canceled, errFail} {
+		token, err := b.Allow()
+		failIfErr(t, err, "closed breaker must allow")
+		b.Record(token, errReq)
+	}
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("want open, got %s", s)
+	}
+	if b.Successes() != 2 || b.Failures() != 2 {
+		t.Fatalf("want 2 and 2, got %d and %d", b.Successes(), b.Failures())
+	}
+}
+
+func TestBreaker_StaleResults(t *testing.T) {
+	b := NewBreaker(BreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         10 * time.Millisecond,
+		HalfOpenRequests:    2,
+	})
+
+	errFail := errors.New("fail")
+	late, err := b.Allow()
+	failIfErr(t, err, "closed breaker must allow")
+	lateCancel, err := b.Allow()
+	failIfErr(t, err, "closed breaker must allow")
+	token, err := b.Allow()
+	failIfErr(t, err, "closed breaker must allow")
+	b.Record(token, errFail)
+
+	time.Sleep(20 * time.Millisecond)
+	trial, err := b.Allow()
+	failIfErr(t, err, "half-open breaker must allow a trial")
+
+	// requests allowed while closed don't count as trials
+	b.Record(late, nil)
+	b.Record(lateCancel, context.Canceled)
+	if s := b.State(); s != BreakerHalfOpen {
+		t.Fatalf("want half-open, got %s", s)
+	}
+	if _, err := b.Allow(); err != nil {
+		t.Fatalf("want the 2nd trial, got %v", err)
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("want only 2 trials, got %v", err)
+	}
+
+	b.Record(trial, errFail)
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("want open, got %s", s)
+	}
+}
+
+func TestBreaker_StaleFailure(t *testing.T) {
+	b := NewBreaker(BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: 10 * time.Millisecond})
+
+	late, err := b.Allow()
+	failIfErr(t, err, "closed breaker must allow")
+	token, err := b.Allow()
+	failIfErr(t, err, "closed breaker must allow")
+	b.Record(token, errors.New("fail"))
+
+	time.Sleep(20 * time.Millisecond)
+	token, err = b.Allow()
+	failIfErr(t, err, "half-open breaker must allow a trial")
+	b.Record(token, nil)
+
+	// a failure of a request allowed before the circuit opened
+	b.Record(late, errors.New("fail"))
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("want closed, got %s", s)
+	}
+}
+
+func TestDialer_Breaker(t *testing.T) {
+	addr := closedAddr(t)
+	d := &Dialer{Breaker: NewBreaker(BreakerConfig{ConsecutiveFailures: 1})}
+
+	if _, err := d.Dial("tcp", addr); err == nil || errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("want dial error, got %v", err)
+	}
+	if _, err := d.Dial("tcp", addr); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("want ErrBreakerOpen, got %v", err)
+	}
+
+	_, err := NewConnPoolWithConfig(context.Background(), addr, 1, ConnPoolConfig{Breaker: d.Breaker})
+	if !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("want ErrBreakerOpen, got %v", err)
+	}
+}
+
+func TestConnPool_BreakerWait(t *testing.T) {
+	b := NewBreaker(BreakerConfig{ConsecutiveFailures: 1, Window: 5})
+	p, err := NewConnPoolWithConfig(context.Background(), newEchoServer(t), 1, ConnPoolConfig{Breaker: b})
+	failIfErr(t, err, "cannot create pool: %s", err)
+	defer p.Close()
+
+	conn, err := p.Acquire(context.Background())
+	failIfErr(t, err, "cannot acquire: %s", err)
+
+	// the pool is exhausted, the backend is fine
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("want closed, got %s", s)
+	}
+	p.Release(conn)
+}
@@ -0,0 +1,137 @@
+package netx
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBufferPool is used by netx internals, buffers from 64B to 4MB are pooled.
+var DefaultBufferPool = NewBufferPool(64, 4<<20)
+
+// BufferPool is a pool of byte slices with power-of-two size classes.
+//
+// Build with the netxdebug tag to panic on double Put and foreign buffers.
+type BufferPool struct {
+	minClass int
+	classes  []bufferClass
+	headers  sync.Pool // *[]byte passed from Get to Put, so Put doesn't allocate
+
+	hits   atomicCounter
+	misses atomicCounter
+
+	debug bufferPoolDebug
+}
+
+// bufferClass is a pool of buffers of one size.
+type bufferClass struct {
+	pool        sync.Pool
+	outstanding atomicCounter // taken with Get and not returned with Put
+}
+
+// NewBufferPool returns new pool for buffers from minSize to maxSize bytes,
+// both are rounded up to a power of two.
+func NewBufferPool(minSize, maxSize int) *BufferPool {
+	if minSize < 1 {
+		minSize = 1
+	}
+	if maxSize < minSize {
+		maxSize = minSize
+	}
+	minClass := sizeClass(minSize)
+	return &BufferPool{
+		minClass: minClass,
+		classes:  make([]bufferClass, sizeClass(maxSize)-minClass+1),
+	}
+}
+
+// Get returns a buffer of length n, its content is undefined.
+func (p *BufferPool) Get(n int) []byte {
+	i := p.index(n)
+	if i < 0 {
+		atomic.AddUint64(&p.misses.count, 1)
+		return make([]byte, n)
+	}
+	class := &p.classes[i]
+	atomic.AddUint64(&class.outstanding.count, 1)
+
+	var b []byte
+	if v, ok := class.pool.Get().(*[]byte); ok {
+		atomic.AddUint64(&p.hits.count, 1)
+		b = (*v)[:n]
+		*v = nil
+		p.headers.Put(v)
+	} else {
+		atomic.AddUint64(&p.misses.count, 1)
+		b = make([]byte, n, 1<<(i+p.minClass))
+	}
+	p.debug.get(b)
+	return b
+}
+
+// Put returns the buffer to the pool, b must not be used after.
+// Buffers with a capacity other than a size class are dropped, so are buffers
+// put when none of their class is outstanding. The pool can't tell its own buffer
+// from a foreign one of the same class, build with the netxdebug tag to catch them.
+func (p *BufferPool) Put(b []byte) {
+	c := cap(b)
+	i := p.index(c)
+	if i < 0 || 1<<(i+p.minClass) != c {
+		return
+	}
+	p.debug.put(b)
+
+	class := &p.classes[i]
+	for {
+		out := atomic.LoadUint64(&class.outstanding.count)
+		if out == 0 {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&class.outstanding.count, out, out-1) {
+			break
+		}
+	}
+
+	v, ok := p.headers.Get().(*[]byte)
+	if !ok {
+		v = new([]byte)
+	}
+	*v = b[:0]
+	class.pool.Put(v)
+}
+
+// Hits is the number of Get calls served from the pool.
+func (p *BufferPool) Hits() uint64 { return atomic.LoadUint64(&p.hits.count) }
+
+// Misses is the number of Get calls that allocated a buffer.
+func (p *BufferPool) Misses() uint64 { return atomic.LoadUint64(&p.misses.count) }
+
+// Outstanding is the number of pooled buffers taken with Get and not returned with Put,
+// buffers too big for the pool are not counted.
+func (p *BufferPool) Outstanding() int64 {
+	var res uint64
+	for i := range p.classes {
+		res += atomic.LoadUint64(&p.classes[i].outstanding.count)
+	}
+	return int64(res)
+}
+
+// index returns the pool index for n bytes, -1 if n is not pooled.
+func (p *BufferPool) index(n int) int {
+	i := sizeClass(n) - p.minClass
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(p.classes) {
+		return -1
+	}
+	return i
+}
+
+// sizeClass returns log2 of the smallest power of two that is not less than n.
+func sizeClass(n int) int {
+	if n <= 1 {
+		return 0
+	}
+	return bits.Len(uint(n - 1))
+}
@@ -0,0 +1,32 @@
+//go:build netxdebug
+
+package netx
+
+import "sync"
+
+// bufferPoolDebug tracks buffers taken from the pool to catch double and foreign Puts.
+type bufferPoolDebug struct {
+	mu          sync.Mutex
+	outstanding map[*byte]struct{}
+}
+
+func (d *bufferPoolDebug) get(b []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.outstanding == nil {
+		d.outstanding = map[*byte]struct{}{}
+	}
+	d.outstanding[&b[:1][0]] = struct{}{}
+}
+
+func (d *bufferPoolDebug) put(b []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := &b[:1][0]
+	if _, ok := d.outstanding[key]; !ok {
+		panic("netx: BufferPool.Put of a buffer that is not taken from the pool (double Put?)")
+	}
+	delete(d.outstanding, key)
+}
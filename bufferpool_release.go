@@ -0,0 +1,9 @@
+//go:build !netxdebug
+
+package netx
+
+// bufferPoolDebug is a no-op without the netxdebug build tag.
+type bufferPoolDebug struct{}
+
+func (bufferPoolDebug) get(b []byte) {}
+func (bufferPoolDebug) put(b []byte) {}
@@ -0,0 +1,61 @@
+package netx
+
+import "testing"
+
+func TestBufferPool(t *testing.T) {
+	p := NewBufferPool(64, 1024)
+
+	b := p.Get(100)
+	if len(b) != 100 || cap(b) != 128 {
+		t.Fatalf("want len 100 cap 128, got len %d cap %d", len(b), cap(b))
+	}
+	if got := p.Outstanding(); got != 1 {
+		t.Fatalf("want 1 outstanding, got %d", got)
+	}
+	p.Put(b)
+	if got := p.Outstanding(); got != 0 {
+		t.Fatalf("want 0 outstanding, got %d", got)
+	}
+
+	if b := p.Get(10); cap(b) != 64 {
+		t.Fatalf("want cap 64, got %d", cap(b))
+	}
+	if b := p.Get(2048); len(b) != 2048 {
+		t.Fatalf("want len 2048, got %d", len(b))
+	}
+	if got := p.Hits() + p.Misses(); got != 3 {
+		t.Fatalf("want 3 gets, got %d", got)
+	}
+	if got := p.Outstanding(); got != 1 {
+		t.Fatalf("want 1 outstanding without the unpooled buffer, got %d", got)
+	}
+
+	// buffers of other sizes are dropped
+	p.Put(make([]byte, 100))
+	p.Put(make([]byte, 4096))
+}
+
+func TestBufferPool_Foreign(t *testing.T) {
+	p := NewBufferPool(64, 1024)
+
+	func() {
+		defer func() { recover() }() // netxdebug panics on foreign buffers
+		p.Put(make([]byte, 128))
+	}()
+	if got := p.Outstanding(); got != 0 {
+		t.Fatalf("want 0 outstanding, got %d", got)
+	}
+	if p.Get(100); p.Hits() != 0 {
+		t.Fatal("foreign buffer must not be pooled")
+	}
+}
+
+func BenchmarkBufferPool(b *testing.B) {
+	p := NewBufferPool(64, 1024)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.Put(p.Get(512))
+		}
+	})
+}
@@ -2,10 +2,12 @@ package netx
 
 import (
 	"context"
-	"errors"
 	"io"
 	"net"
+	"os"
 	"sync"
+	"syscall"
+	"time"
 )
 
 // Conn is a stream oriented network connection with i/o operations that are controlled by Contexts
@@ -21,27 +23,97 @@ type CtxConn interface {
 //
 // See: TCPListener or UDPListener how to create it.
 type Conn struct {
-	net.TCPConn
+	*net.TCPConn
 	stats     *Stats
+	shard     uint32 // of stats io counters
 	closeOnce sync.Once
+
+	readLimit  rateLimiters
+	writeLimit rateLimiters
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	minRead      *rateFloor
+	minWrite     *rateFloor
+	idle         *idleWheel
+	idleSlot     int   // guarded by idle.mu
+	activeAt     int64 // unix nano, accessed atomically
+
+	sampleTCPInfo bool
+}
+
+func newConn(tcpconn *net.TCPConn, stats *Stats, readLimiter, writeLimiter *RateLimiter) *Conn {
+	return &Conn{
+		TCPConn:  tcpconn,
+		stats:    stats,
+		shard:    pickShard(),
+		idleSlot: -1,
+		readLimit: rateLimiters{
+			conn:     &RateLimiter{},
+			listener: readLimiter,
+		},
+		writeLimit: rateLimiters{
+			conn:     &RateLimiter{},
+			listener: writeLimiter,
+		},
+	}
+}
+
+// ReadLimiter limits the read throughput of the connection.
+// See also TCPListener.ReadLimiter for the limit shared by all connections.
+func (c *Conn) ReadLimiter() *RateLimiter {
+	return c.readLimit.conn
+}
+
+// WriteLimiter limits the write throughput of the connection.
+// See also TCPListener.WriteLimiter for the limit shared by all connections.
+func (c *Conn) WriteLimiter() *RateLimiter {
+	return c.writeLimit.conn
+}
+
+// Control invokes fn on the underlying file descriptor.
+// The fd is valid only during the fn call.
+func (c *Conn) Control(fn func(fd uintptr)) error {
+	rc, err := c.TCPConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return rc.Control(fn)
+}
+
+// SyscallConn returns a raw network connection.
+//
+// Returned value hides the runtime poller so zero-copy transfers from os.File
+// go through ReadFrom and are counted in Stats.
+func (c *Conn) SyscallConn() (syscall.RawConn, error) {
+	rc, err := c.TCPConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	return rawConn{rc}, nil
+}
+
+type rawConn struct {
+	syscall.RawConn
 }
 
 // ReadContext does same as Read method but with a context.
 // This method requires 1 additional goroutine from a worker pool.
 func (c *Conn) ReadContext(ctx context.Context, b []byte) (n int, err error) {
-	// TODO: bytes pool
-	buf := make([]byte, len(b))
+	buf := DefaultBufferPool.Get(len(b))
 	// TODO: chan pool
 	ch := make(chan ioResult, 1)
 
 	// TODO: goroutine pool
-	go func() { ch <- newIOResult(c.Read(buf)) }()
+	go func() { ch <- newIOResult(c.read(ctx, buf)) }()
 
 	select {
 	case res := <-ch:
 		copy(b, buf)
+		DefaultBufferPool.Put(buf)
 		return res.n, res.err
 	case <-ctx.Done():
+		// buf is still owned by the read, it's left to GC
 		return 0, ctx.Err()
 	}
 }
@@ -49,14 +121,17 @@ func (c *Conn) ReadContext(ctx context.Context, b []byte) (n int, err error) {
 // WriteContext does same as Write method but with a context.
 // This method requires 1 additional goroutine from a worker pool.
 func (c *Conn) WriteContext(ctx context.Context, b []byte) (n int, err error) {
-	// TODO: bytes pool
-	buf := make([]byte, len(b))
+	buf := DefaultBufferPool.Get(len(b))
 	copy(buf, b)
 	// TODO: chan pool
 	ch := make(chan ioResult, 1)
 
 	// TODO: goroutine pool
-	go func() { ch <- newIOResult(c.Write(buf)) }()
+	go func() {
+		n, err := c.write(ctx, buf)
+		DefaultBufferPool.Put(buf)
+		ch <- newIOResult(n, err)
+	}()
 
 	select {
 	case res := <-ch:
@@ -70,48 +145,289 @@ func (c *Conn) WriteContext(ctx context.Context, b []byte) (n int, err error) {
 // Read can be made to time out and return an error after a fixed
 // time limit; see SetDeadline and SetReadDeadline.
 func (c *Conn) Read(p []byte) (int, error) {
-	n, err := c.TCPConn.Read(p)
-	c.stats.readBytesAdd(n)
-	if err != nil && err != io.EOF {
-		var ne net.Error
-		if errors.As(err, &ne) && ne.Timeout() {
-			c.stats.readTimeoutsInc()
-		} else {
-			c.stats.readErrorsInc()
-		}
-	}
-	return n, err
+	return c.read(context.Background(), p)
 }
 
 // Write writes data to the connection.
 // Write can be made to time out and return an error after a fixed
 // time limit; see SetDeadline and SetWriteDeadline.
 func (c *Conn) Write(p []byte) (int, error) {
-	n, err := c.TCPConn.Write(p)
-	c.stats.writtenBytesAdd(n)
-	if err != nil {
-		var ne net.Error
-		if errors.As(err, &ne) && ne.Timeout() {
-			c.stats.writeTimeoutsInc()
-		} else {
-			c.stats.writeErrorsInc()
+	return c.write(context.Background(), p)
+}
+
+func (c *Conn) read(ctx context.Context, p []byte) (int, error) {
+	if d := ioDeadline(time.Now(), c.readTimeout, c.minRead); !d.IsZero() || c.minRead != nil {
+		c.TCPConn.SetReadDeadline(d)
+	}
+
+	n, err := c.TCPConn.Read(p[:c.readLimit.chunk(len(p))])
+	if c.minRead != nil && (err == nil || os.IsTimeout(err)) && !c.minRead.done(time.Now(), n) {
+		err = c.tooSlow("read")
+	}
+	c.stats.readDone(c.shard, int64(n), err)
+	if n > 0 && c.idle != nil {
+		c.touch()
+	}
+
+	if n > 0 {
+		d, errWait := c.readLimit.wait(ctx, n)
+		c.stats.readThrottledAdd(d)
+		if err == nil {
+			err = errWait
+		}
+	}
+	return n, err
+}
+
+func (c *Conn) write(ctx context.Context, p []byte) (int, error) {
+	var total int
+	var err error
+	for {
+		chunk := p[total : total+c.writeLimit.chunk(len(p)-total)]
+
+		d, errWait := c.writeLimit.wait(ctx, len(chunk))
+		c.stats.writeThrottledAdd(d)
+		if errWait != nil {
+			err = errWait
+			break
+		}
+
+		if d := ioDeadline(time.Now(), c.writeTimeout, c.minWrite); !d.IsZero() || c.minWrite != nil {
+			c.TCPConn.SetWriteDeadline(d)
+		}
+
+		var n int
+		n, err = c.TCPConn.Write(chunk)
+		total += n
+		if c.minWrite != nil && (err == nil || os.IsTimeout(err)) && !c.minWrite.done(time.Now(), n) {
+			err = c.tooSlow("write")
+		}
+		if n > 0 && c.idle != nil {
+			c.touch()
+		}
+		if err != nil || total == len(p) {
+			break
+		}
+	}
+	c.stats.writeDone(c.shard, int64(total), err)
+	return total, err
+}
+
+// tooSlow aborts the connection that is slower than the minimum rate.
+func (c *Conn) tooSlow(op string) error {
+	c.stats.slowClosesInc()
+	c.Abort()
+	return &net.OpError{Op: op, Net: "tcp", Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: ErrTooSlow}
+}
+
+// ReadFrom implements io.ReaderFrom.
+// Kernel zero-copy (splice or sendfile) is used when r is a Conn,
+// a net.TCPConn, an os.File or an io.LimitedReader of them
+// and neither side has a rate limit, a minimum rate, a timeout or an idle timeout.
+//
+// Errors are counted as write errors of c.
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	if !c.plainWrite() {
+		return io.Copy(writerOnly{c}, readerOnly{r})
+	}
+
+	var src *Conn
+	switch rr := r.(type) {
+	case *Conn:
+		if !rr.plainRead() {
+			return io.Copy(writerOnly{c}, readerOnly{r})
+		}
+		src, r = rr, rr.TCPConn
+	case *io.LimitedReader:
+		if conn, ok := rr.R.(*Conn); ok {
+			if !conn.plainRead() {
+				return io.Copy(writerOnly{c}, readerOnly{r})
+			}
+			src = conn
+			lr := &io.LimitedReader{R: conn.TCPConn, N: rr.N}
+			defer func() { rr.N = lr.N }()
+			r = lr
 		}
 	}
+
+	n, err := c.TCPConn.ReadFrom(r)
+	if src != nil {
+		src.stats.readDone(src.shard, n, nil)
+	}
+	c.stats.writeDone(c.shard, n, err)
+	return n, err
+}
+
+// WriteTo implements io.WriterTo.
+// Kernel zero-copy (splice) is used when w is a Conn, a net.TCPConn or an os.File
+// and neither side has a rate limit, a minimum rate, a timeout or an idle timeout.
+//
+// Errors are counted as read errors of c.
+func (c *Conn) WriteTo(w io.Writer) (int64, error) {
+	if !c.plainRead() {
+		return io.Copy(w, readerOnly{c})
+	}
+
+	var n int64
+	var err error
+	switch w := w.(type) {
+	case *Conn:
+		return w.ReadFrom(c)
+	case io.ReaderFrom:
+		n, err = w.ReadFrom(c.TCPConn)
+	default:
+		n, err = io.Copy(w, c.TCPConn)
+	}
+	c.stats.readDone(c.shard, n, err)
 	return n, err
 }
 
+// plainRead reports whether reads can bypass Read,
+// zero-copy doesn't limit rates, set timeouts or touch the idle wheel.
+func (c *Conn) plainRead() bool {
+	return !c.readLimit.limited() && c.minRead == nil && c.readTimeout <= 0 && c.idle == nil
+}
+
+// plainWrite reports whether writes can bypass Write, see plainRead.
+func (c *Conn) plainWrite() bool {
+	return !c.writeLimit.limited() && c.minWrite == nil && c.writeTimeout <= 0 && c.idle == nil
+}
+
+// Close closes the connection, peer receives FIN.
 func (c *Conn) Close() error {
+	return c.close(false)
+}
+
+// Abort closes the connection with SO_LINGER set to 0, peer receives RST.
+func (c *Conn) Abort() error {
+	return c.close(true)
+}
+
+// CloseGracefully shuts down the writing side of the connection
+// and drains reads until EOF, then closes the connection.
+// If ctx is done before EOF the connection is aborted.
+func (c *Conn) CloseGracefully(ctx context.Context) error {
+	if err := c.CloseWrite(); err != nil {
+		c.Abort()
+		return err
+	}
+
+	// ReadTimeout bounds the whole drain, it's not reset per read,
+	// so it doesn't override the deadline set on ctx done.
+	var deadline time.Time
+	if c.readTimeout > 0 {
+		deadline = time.Now().Add(c.readTimeout)
+	}
+	c.TCPConn.SetReadDeadline(deadline)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.TCPConn.SetReadDeadline(aLongTimeAgo)
+		case <-stopCh:
+		}
+	}()
+
+	buf := DefaultBufferPool.Get(512)
+	defer DefaultBufferPool.Put(buf)
+	for {
+		n, err := c.TCPConn.Read(buf)
+		if ctxErr := ctx.Err(); ctxErr != nil && os.IsTimeout(err) {
+			// the timeout is of the graceful close, not of a read
+			c.stats.readBytesAdd(c.shard, int64(n))
+			c.Abort()
+			return ctxErr
+		}
+		c.stats.readDone(c.shard, int64(n), err)
+		if n > 0 && c.idle != nil {
+			c.touch()
+		}
+
+		switch {
+		case err == io.EOF:
+			return c.Close()
+		case err != nil:
+			c.Abort()
+			return err
+		}
+	}
+}
+
+// CloseRead shuts down the reading side of the connection.
+func (c *Conn) CloseRead() error {
+	err := c.TCPConn.CloseRead()
+	c.stats.closeReadsInc()
+	if err != nil {
+		c.stats.closeErrorsInc()
+	}
+	return err
+}
+
+// CloseWrite shuts down the writing side of the connection, peer receives FIN.
+func (c *Conn) CloseWrite() error {
+	err := c.TCPConn.CloseWrite()
+	c.stats.closeWritesInc()
+	if err != nil {
+		c.stats.closeErrorsInc()
+	}
+	return err
+}
+
+func (c *Conn) close(reset bool) error {
 	var err error
 	c.closeOnce.Do(func() {
+		if c.idle != nil {
+			c.idle.remove(c)
+		}
+		if c.sampleTCPInfo {
+			if info, err := c.TCPInfo(); err == nil {
+				c.stats.tcpInfoObserve(info)
+			}
+		}
+
+		if reset {
+			ctrlErr := c.Control(func(fd uintptr) {
+				err = setLinger(int(fd), 0)
+			})
+			if err == nil {
+				err = ctrlErr
+			}
+			if err != nil {
+				c.TCPConn.Close()
+				c.stats.connsInc()
+				c.stats.closeErrorsInc()
+				return
+			}
+		}
+
 		err = c.TCPConn.Close()
 		c.stats.connsInc()
 		if err != nil {
 			c.stats.closeErrorsInc()
+			return
+		}
+		if reset {
+			c.stats.resetClosesInc()
+		} else {
+			c.stats.finClosesInc()
 		}
 	})
 	return err
 }
 
+// aLongTimeAgo is a non-zero time, far in the past, used for immediate cancellation of I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
+type readerOnly struct {
+	io.Reader
+}
+
+type writerOnly struct {
+	io.Writer
+}
+
 type ioResult struct {
 	n   int
 	err error
@@ -0,0 +1,221 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestConn_Abort(t *testing.T) {
+	ln, client, conn := newTestConn(t)
+	defer client.Close()
+
+	err := conn.Abort()
+	failIfErr(t, err, "cannot abort: %s", err)
+
+	_, err = io.ReadAll(client)
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("want connection reset, got %v", err)
+	}
+	if got := ln.Stats().ResetCloses(); got != 1 {
+		t.Fatalf("want 1 reset close, got %d", got)
+	}
+}
+
+func TestConn_CloseGracefully(t *testing.T) {
+	ln, client, conn := newTestConn(t)
+	defer client.Close()
+
+	go func() {
+		io.Copy(io.Discard, client)
+		client.Write([]byte("bye"))
+		client.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := conn.CloseGracefully(ctx)
+	failIfErr(t, err, "cannot close gracefully: %s", err)
+
+	stats := ln.Stats()
+	if got := stats.CloseWrites(); got != 1 {
+		t.Fatalf("want 1 close write, got %d", got)
+	}
+	if got := stats.FinCloses(); got != 1 {
+		t.Fatalf("want 1 fin close, got %d", got)
+	}
+	if got := stats.ReadBytes(); got != 3 {
+		t.Fatalf("want 3 drained bytes, got %d", got)
+	}
+}
+
+func TestConn_CloseGracefullyTimeout(t *testing.T) {
+	ln, client, conn := newTestConn(t)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := conn.CloseGracefully(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+	if got := ln.Stats().ResetCloses(); got != 1 {
+		t.Fatalf("want 1 reset close, got %d", got)
+	}
+	if got := ln.Stats().ReadTimeouts(); got != 0 {
+		t.Fatalf("want no read timeouts, got %d", got)
+	}
+}
+
+func TestConn_ReadFromFile(t *testing.T) {
+	ln, client, conn := newTestConn(t)
+	defer client.Close()
+
+	data := bytes.Repeat([]byte("netx"), 64*1024)
+	f, err := os.CreateTemp(t.TempDir(), "netx")
+	failIfErr(t, err, "cannot create file: %s", err)
+	defer f.Close()
+
+	_, err = f.Write(data)
+	failIfErr(t, err, "cannot write file: %s", err)
+	_, err = f.Seek(0, io.SeekStart)
+	failIfErr(t, err, "cannot seek file: %s", err)
+
+	go func() {
+		io.Copy(conn, f)
+		conn.Close()
+	}()
+
+	got, err := io.ReadAll(client)
+	failIfErr(t, err, "cannot read: %s", err)
+
+	if !bytes.Equal(got, data) {
+		t.Fatalf("want %d bytes, got %d", len(data), len(got))
+	}
+	if got := ln.Stats().WrittenBytes(); got != uint64(len(data)) {
+		t.Fatalf("want %d written bytes, got %d", len(data), got)
+	}
+	if got := ln.Stats().WriteCalls(); got != 1 {
+		t.Fatalf("want 1 write call, got %d", got)
+	}
+}
+
+func TestConn_SocketToSocket(t *testing.T) {
+	srcLn, srcClient, src := newTestConn(t)
+	defer srcClient.Close()
+	dstLn, dstClient, dst := newTestConn(t)
+	defer dstClient.Close()
+
+	data := bytes.Repeat([]byte("netx"), 64*1024)
+	go func() {
+		srcClient.Write(data)
+		srcClient.Close()
+	}()
+	go func() {
+		io.Copy(dst, src)
+		dst.Close()
+	}()
+
+	got, err := io.ReadAll(dstClient)
+	failIfErr(t, err, "cannot read: %s", err)
+
+	if !bytes.Equal(got, data) {
+		t.Fatalf("want %d bytes, got %d", len(data), len(got))
+	}
+	if got := srcLn.Stats().ReadBytes(); got != uint64(len(data)) {
+		t.Fatalf("want %d read bytes, got %d", len(data), got)
+	}
+	if got := dstLn.Stats().WrittenBytes(); got != uint64(len(data)) {
+		t.Fatalf("want %d written bytes, got %d", len(data), got)
+	}
+	if got := dstLn.Stats().WriteErrors(); got != 0 {
+		t.Fatalf("want no write errors, got %d", got)
+	}
+}
+
+func TestConn_RateLimit(t *testing.T) {
+	ln, client, conn := newTestConn(t)
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+
+	conn.WriteLimiter().SetLimit(100*1024, 10*1024)
+
+	start := time.Now()
+	_, err := conn.Write(make([]byte, 30*1024))
+	failIfErr(t, err, "cannot write: %s", err)
+
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("write must be throttled, took %s", elapsed)
+	}
+	if got := ln.Stats().WriteThrottled(); got == 0 {
+		t.Fatal("want throttled time in stats")
+	}
+
+	ln.WriteLimiter().SetLimit(1024, 1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = conn.WriteContext(ctx, make([]byte, 4*1024))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+}
+
+func newTestConn(tb testing.TB) (*TCPListener, net.Conn, *Conn) {
+	tb.Helper()
+
+	ln, err := NewTCPListener(context.Background(), "tcp4", "127.0.0.1:0", TCPListenerConfig{})
+	failIfErr(tb, err, "cannot create listener: %s", err)
+	tb.Cleanup(func() { ln.Close() })
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	failIfErr(tb, err, "cannot dial: %s", err)
+
+	conn, err := ln.Accept()
+	failIfErr(tb, err, "cannot accept: %s", err)
+	return ln, client, conn.(*Conn)
+}
+
+func TestConn_CopyIdleTimeout(t *testing.T) {
+	cfg := TCPListenerConfig{IdleTimeout: 100 * time.Millisecond}
+	ln, err := NewTCPListener(context.Background(), "tcp4", "127.0.0.1:0", cfg)
+	failIfErr(t, err, "cannot create listener: %s", err)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer client.Close()
+
+	conn, err := ln.Accept()
+	failIfErr(t, err, "cannot accept: %s", err)
+	go func() {
+		io.Copy(conn, conn)
+		conn.Close()
+	}()
+
+	// io.Copy keeps the connection active
+	for i := 0; i < 30; i++ {
+		_, err := client.Write([]byte("x"))
+		failIfErr(t, err, "cannot write: %s", err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	client.(*net.TCPConn).CloseWrite()
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	got, err := io.ReadAll(client)
+	failIfErr(t, err, "cannot read: %s", err)
+	if len(got) != 30 {
+		t.Fatalf("want 30 echoed bytes, got %d", len(got))
+	}
+	if n := ln.Stats().IdleCloses(); n != 0 {
+		t.Fatalf("want no idle closes, got %d", n)
+	}
+}
@@ -3,20 +3,60 @@ package netx
 import (
 	"context"
 	"net"
+	"sync"
 )
 
+// ConnPool is a fixed size pool of connections to a single address.
 type ConnPool struct {
+	addr   string
+	cfg    ConnPoolConfig
 	connCh chan net.Conn
+	slotCh chan struct{} // a token per discarded connection to redial
+
+	mu     sync.Mutex
+	tokens map[net.Conn]BreakerToken // of acquired connections, with Breaker
+}
+
+// ConnPoolConfig is a config for ConnPool.
+type ConnPoolConfig struct {
+	// Dial dials a connection (default net.Dialer with "tcp" network).
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+
+	// Resolver, if set, resolves the host for the default Dial.
+	Resolver *Resolver
+
+	// Breaker, if set, guards Acquire: an open circuit fails fast
+	// with *BreakerOpenError. Dial errors and the errors passed to Discard
+	// are recorded as failures, Release is recorded as a success.
+	// Timeouts of waiting for a busy pool are not recorded.
+	Breaker *Breaker
 }
 
 func NewConnPool(addr string, size int) (*ConnPool, error) {
+	return NewConnPoolWithConfig(context.Background(), addr, size, ConnPoolConfig{})
+}
+
+// NewConnPoolWithConfig returns a pool of size connections dialed to addr.
+func NewConnPoolWithConfig(ctx context.Context, addr string, size int, cfg ConnPoolConfig) (*ConnPool, error) {
+	if cfg.Dial == nil {
+		cfg.Dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			d := Dialer{Resolver: cfg.Resolver}
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+
 	p := &ConnPool{
+		addr:   addr,
+		cfg:    cfg,
 		connCh: make(chan net.Conn, size),
+		slotCh: make(chan struct{}, size),
+		tokens: map[net.Conn]BreakerToken{},
 	}
 
 	for i := 0; i < size; i++ {
-		conn, err := net.Dial("tcp", addr)
+		conn, err := p.dial(ctx)
 		if err != nil {
+			p.Close()
 			return nil, err
 		}
 		p.connCh <- conn
@@ -24,15 +64,113 @@ func NewConnPool(addr string, size int) (*ConnPool, error) {
 	return p, nil
 }
 
+// Acquire returns an idle connection or redials a discarded one.
+// Every acquired connection must be returned with Release or Discard.
 func (p *ConnPool) Acquire(ctx context.Context) (net.Conn, error) {
+	var token BreakerToken
+	if b := p.cfg.Breaker; b != nil {
+		var err error
+		if token, err = b.Allow(); err != nil {
+			return nil, err
+		}
+	}
+
+	select {
+	case conn := <-p.connCh:
+		return p.acquired(conn, token), nil
+	default:
+	}
+
 	select {
 	case <-ctx.Done():
+		// waiting for a busy pool says nothing about the backend,
+		// context.Canceled is not counted by the breaker
+		p.record(token, context.Canceled)
 		return nil, ctx.Err()
 	case conn := <-p.connCh:
-		return conn, nil
+		return p.acquired(conn, token), nil
+	case <-p.slotCh:
+		conn, err := p.cfg.Dial(ctx, p.addr)
+		if err != nil {
+			p.slotCh <- struct{}{}
+			p.record(token, err)
+			return nil, err
+		}
+		return p.acquired(conn, token), nil
 	}
 }
 
+// Release returns a healthy connection to the pool.
 func (p *ConnPool) Release(conn net.Conn) {
+	token, ok := p.released(conn)
 	p.connCh <- conn
+	if ok {
+		p.record(token, nil)
+	}
+}
+
+// Discard closes a broken connection, it will be redialed by Acquire.
+// err is recorded as a failure by the breaker, if any.
+func (p *ConnPool) Discard(conn net.Conn, err error) {
+	token, ok := p.released(conn)
+	conn.Close()
+	p.slotCh <- struct{}{}
+	if ok {
+		p.record(token, err)
+	}
+}
+
+// acquired keeps the breaker token of the conn until it's released.
+func (p *ConnPool) acquired(conn net.Conn, token BreakerToken) net.Conn {
+	if p.cfg.Breaker != nil {
+		p.mu.Lock()
+		p.tokens[conn] = token
+		p.mu.Unlock()
+	}
+	return conn
+}
+
+// released returns the breaker token of the conn, false if it has none.
+func (p *ConnPool) released(conn net.Conn) (BreakerToken, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	token, ok := p.tokens[conn]
+	delete(p.tokens, conn)
+	return token, ok
+}
+
+// Close closes idle connections.
+func (p *ConnPool) Close() error {
+	var err error
+	for {
+		select {
+		case conn := <-p.connCh:
+			if errClose := conn.Close(); err == nil {
+				err = errClose
+			}
+		default:
+			return err
+		}
+	}
+}
+
+// dial dials a connection through the breaker, if any.
+func (p *ConnPool) dial(ctx context.Context) (net.Conn, error) {
+	var token BreakerToken
+	if b := p.cfg.Breaker; b != nil {
+		var err error
+		if token, err = b.Allow(); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := p.cfg.Dial(ctx, p.addr)
+	p.record(token, err)
+	return conn, err
+}
+
+func (p *ConnPool) record(token BreakerToken, err error) {
+	if p.cfg.Breaker != nil {
+		p.cfg.Breaker.Record(token, err)
+	}
 }
@@ -0,0 +1,111 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
+// Dialer is a net.Dialer with an optional circuit breaker and resolver.
+type Dialer struct {
+	net.Dialer
+
+	// Resolver, if set, resolves hosts with a cache.
+	Resolver *Resolver
+
+	// Breaker, if set, fails dials fast while the circuit is open
+	// and records the result of every dial.
+	Breaker *Breaker
+}
+
+// Dial connects to the address on the named network.
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to the address on the named network using the provided context.
+// Error of an open circuit is *net.OpError wrapping *BreakerOpenError.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.Breaker == nil {
+		return d.dial(ctx, network, addr)
+	}
+
+	token, err := d.Breaker.Allow()
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	conn, err := d.dial(ctx, network, addr)
+	d.Breaker.Record(token, err)
+	return conn, err
+}
+
+func (d *Dialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.Resolver == nil {
+		return d.Dialer.DialContext(ctx, network, addr)
+	}
+	return dialResolved(ctx, d.Resolver, &d.Dialer, network, addr)
+}
+
+// instrumentConn counts i/o of a dialed connection in stats,
+// TCP connections become *Conn.
+func instrumentConn(conn net.Conn, stats *Stats) net.Conn {
+	if tcpconn, ok := conn.(*net.TCPConn); ok {
+		return newConn(tcpconn, stats, &RateLimiter{}, &RateLimiter{})
+	}
+	return &statsConn{Conn: conn, stats: stats, shard: pickShard()}
+}
+
+// statsConn counts i/o of a connection that is not TCP, like TLS, in stats.
+type statsConn struct {
+	net.Conn
+	stats *Stats
+	shard uint32 // of stats io counters
+}
+
+func (c *statsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.readDone(c.shard, int64(n), err)
+	return n, err
+}
+
+func (c *statsConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.writeDone(c.shard, int64(n), err)
+	return n, err
+}
+
+func (c *statsConn) Close() error {
+	err := c.Conn.Close()
+	c.stats.connsInc()
+	if err != nil {
+		c.stats.closeErrorsInc()
+	}
+	return err
+}
+
+// CloseWrite shuts down the writing side of the connection if it's supported.
+func (c *statsConn) CloseWrite() error {
+	cw, ok := c.Conn.(interface{ CloseWrite() error })
+	if !ok {
+		return ErrNotSupported
+	}
+	err := cw.CloseWrite()
+	c.stats.closeWritesInc()
+	if err != nil {
+		c.stats.closeErrorsInc()
+	}
+	return err
+}
+
+// hostAddr is an address with a host that may be not resolved.
+type hostAddr struct {
+	network string
+	host    string
+	port    int
+}
+
+func (a *hostAddr) Network() string { return a.network }
+
+func (a *hostAddr) String() string {
+	return net.JoinHostPort(a.host, strconv.Itoa(a.port))
+}
@@ -0,0 +1,606 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// EventLoopConfig is a config for EventLoop.
+type EventLoopConfig struct {
+	// Listener configures the listening socket,
+	// only socket options are used, limits and timeouts are ignored.
+	Listener TCPListenerConfig
+
+	// Loops is the number of loop goroutines (default GOMAXPROCS).
+	Loops int
+
+	// ReadBufferSize is the max number of bytes passed to OnData at once (default 64KB).
+	ReadBufferSize int
+
+	// OnOpen is called when a connection is accepted.
+	OnOpen func(c *EventConn)
+
+	// OnData is called when data is read from the connection,
+	// data is valid only during the call.
+	OnData func(c *EventConn, data []byte)
+
+	// OnClose is called when the connection is closed,
+	// err is nil when it's closed by the peer or with EventConn.Close.
+	OnClose func(c *EventConn, err error)
+}
+
+// EventLoop serves TCP connections from a small set of goroutines
+// with epoll on Linux and kqueue on BSD.
+//
+// Unlike TCPListener there is no goroutine per connection and read buffers
+// are taken from DefaultBufferPool only when data is ready,
+// use it to hold a lot of mostly idle connections.
+type EventLoop struct {
+	cfg   EventLoopConfig
+	fd    int
+	addr  net.Addr
+	loops []*eventLoop
+	stats *Stats
+
+	acceptDelay  time.Duration // accessed only by the first loop
+	acceptResume int32         // set by the accept backoff, accessed atomically
+
+	pollersMu     sync.Mutex
+	pollersClosed bool
+
+	closeOnce sync.Once
+	doneCh    chan struct{}
+}
+
+// NewEventLoop returns new EventLoop listening on the addr.
+// Call Serve to start it, the listening socket is closed when Serve returns.
+func NewEventLoop(ctx context.Context, network, addr string, cfg EventLoopConfig) (*EventLoop, error) {
+	if cfg.Loops <= 0 {
+		cfg.Loops = runtime.GOMAXPROCS(0)
+	}
+	if cfg.ReadBufferSize <= 0 {
+		cfg.ReadBufferSize = 64 * 1024
+	}
+
+	fd, err := cfg.Listener.newSocket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, newError("getsockname", err)
+	}
+
+	el := &EventLoop{
+		cfg:    cfg,
+		fd:     fd,
+		addr:   sockaddrToTCPAddr(sa),
+		stats:  &Stats{},
+		doneCh: make(chan struct{}),
+	}
+	for i := 0; i < cfg.Loops; i++ {
+		p, err := newPoller()
+		if err != nil {
+			el.closePollers()
+			syscall.Close(fd)
+			return nil, err
+		}
+		el.loops = append(el.loops, &eventLoop{
+			el:     el,
+			poller: p,
+			shard:  pickShard(),
+			conns:  map[int]*EventConn{},
+		})
+	}
+
+	if err := el.loops[0].poller.addRead(fd); err != nil {
+		el.closePollers()
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			el.Close()
+		case <-el.doneCh:
+		}
+	}()
+	return el, nil
+}
+
+// Serve runs the loops and blocks until the EventLoop is closed.
+// Connections are closed before Serve returns.
+func (el *EventLoop) Serve() error {
+	errCh := make(chan error, len(el.loops))
+	var wg sync.WaitGroup
+	for _, l := range el.loops {
+		wg.Add(1)
+		go func(l *eventLoop) {
+			defer wg.Done()
+			if err := l.run(); err != nil {
+				errCh <- err
+				el.Close()
+			}
+		}(l)
+	}
+	wg.Wait()
+
+	el.Close() // waits for the wake ups
+	for _, l := range el.loops {
+		l.closeAll() // conns accepted after the loop has stopped
+	}
+	el.closePollers()
+	syscall.Close(el.fd)
+
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// Close stops the loops, Serve closes the connections and returns.
+func (el *EventLoop) Close() error {
+	el.closeOnce.Do(func() {
+		close(el.doneCh)
+		for _, l := range el.loops {
+			l.poller.wake()
+		}
+	})
+	return nil
+}
+
+// Addr returns the listener address.
+func (el *EventLoop) Addr() net.Addr {
+	return el.addr
+}
+
+// Stats of the listener and accepted connections.
+func (el *EventLoop) Stats() *Stats {
+	return el.stats
+}
+
+func (el *EventLoop) closePollers() {
+	el.pollersMu.Lock()
+	defer el.pollersMu.Unlock()
+
+	el.pollersClosed = true
+	for _, l := range el.loops {
+		l.poller.close()
+	}
+}
+
+func (el *EventLoop) accept() {
+	for {
+		fd, sa, err := acceptCloexec(el.fd)
+		switch err {
+		case nil:
+		case syscall.EAGAIN:
+			return
+		case syscall.EINTR, syscall.ECONNABORTED:
+			continue
+		default:
+			el.stats.acceptsInc()
+			el.stats.acceptErrorsInc()
+			el.pauseAccept()
+			return
+		}
+		el.stats.acceptsInc()
+		el.acceptDelay = 0
+
+		if el.cfg.Listener.OnAccept != nil {
+			if err := el.cfg.Listener.OnAccept(uintptr(fd)); err != nil {
+				syscall.Close(fd)
+				el.stats.acceptErrorsInc()
+				continue
+			}
+		}
+
+		l := el.loops[fd%len(el.loops)]
+		c := &EventConn{
+			fd:     fd,
+			loop:   l,
+			remote: sockaddrToTCPAddr(sa),
+		}
+		l.schedule(c)
+	}
+}
+
+// pauseAccept stops accepting for a while after an accept error.
+// The listening socket stays readable on errors like EMFILE,
+// so the loop would spin without the pause.
+func (el *EventLoop) pauseAccept() {
+	switch {
+	case el.acceptDelay == 0:
+		el.acceptDelay = 5 * time.Millisecond
+	case el.acceptDelay < time.Second:
+		el.acceptDelay *= 2
+	}
+
+	l := el.loops[0]
+	if err := l.poller.delRead(el.fd); err != nil {
+		return
+	}
+	time.AfterFunc(el.acceptDelay, func() {
+		atomic.StoreInt32(&el.acceptResume, 1)
+
+		el.pollersMu.Lock()
+		if !el.pollersClosed {
+			l.poller.wake()
+		}
+		el.pollersMu.Unlock()
+	})
+}
+
+// eventLoop is a single loop goroutine with its own poller.
+type eventLoop struct {
+	el     *EventLoop
+	poller *poller
+	shard  uint32 // of stats io counters
+
+	mu      sync.Mutex
+	conns   map[int]*EventConn
+	pending []*EventConn // to be opened or closed by the loop
+}
+
+func (l *eventLoop) run() error {
+	for {
+		err := l.poller.wait(l.handle)
+		if err != nil {
+			l.closeAll()
+			return err
+		}
+
+		select {
+		case <-l.el.doneCh:
+			l.closeAll()
+			return nil
+		default:
+		}
+		if l == l.el.loops[0] && atomic.CompareAndSwapInt32(&l.el.acceptResume, 1, 0) {
+			if err := l.poller.addRead(l.el.fd); err != nil {
+				l.closeAll()
+				return err
+			}
+		}
+		l.runPending()
+	}
+}
+
+func (l *eventLoop) handle(fd int, readable, writable bool) {
+	if fd == l.el.fd {
+		l.el.accept()
+		return
+	}
+
+	l.mu.Lock()
+	c := l.conns[fd]
+	l.mu.Unlock()
+	if c == nil {
+		return
+	}
+
+	if writable {
+		if err := c.flush(); err != nil {
+			l.close(c, err)
+			return
+		}
+		if c.drained() {
+			l.close(c, nil)
+			return
+		}
+	}
+	if readable {
+		l.read(c)
+	}
+}
+
+func (l *eventLoop) read(c *EventConn) {
+	buf := DefaultBufferPool.Get(l.el.cfg.ReadBufferSize)
+	defer DefaultBufferPool.Put(buf)
+
+	n, err := syscall.Read(c.fd, buf)
+	switch {
+	case err == syscall.EAGAIN || err == syscall.EINTR:
+		return
+	case err != nil:
+		err = &net.OpError{Op: "read", Net: "tcp", Source: l.el.addr, Addr: c.remote, Err: newError("read", err)}
+		l.el.stats.readDone(l.shard, 0, err)
+		l.close(c, err)
+		return
+	case n == 0:
+		l.closeByPeer(c)
+		return
+	}
+
+	l.el.stats.readDone(l.shard, int64(n), nil)
+	if l.el.cfg.OnData != nil {
+		l.el.cfg.OnData(c, buf[:n])
+	}
+}
+
+// schedule passes c to the loop to be opened or closed.
+func (l *eventLoop) schedule(c *EventConn) {
+	l.mu.Lock()
+	l.pending = append(l.pending, c)
+	l.mu.Unlock()
+	l.poller.wake()
+}
+
+func (l *eventLoop) runPending() {
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	for _, c := range pending {
+		if !c.opened {
+			c.opened = true
+			if err := l.poller.addRead(c.fd); err != nil {
+				syscall.Close(c.fd)
+				l.el.stats.acceptErrorsInc()
+				continue
+			}
+			l.mu.Lock()
+			l.conns[c.fd] = c
+			l.mu.Unlock()
+
+			l.el.stats.activeConnsInc()
+			if l.el.cfg.OnOpen != nil {
+				l.el.cfg.OnOpen(c)
+			}
+		}
+
+		c.mu.Lock()
+		closing := c.closing
+		c.mu.Unlock()
+		if closing {
+			l.close(c, nil)
+		}
+	}
+}
+
+// closeByPeer closes the connection after EOF once the buffered bytes are written.
+func (l *eventLoop) closeByPeer(c *EventConn) {
+	c.mu.Lock()
+	if len(c.out) == 0 {
+		c.mu.Unlock()
+		l.close(c, nil)
+		return
+	}
+	// there is nothing to read, EOF would be reported again
+	c.draining = true
+	c.writing = true
+	err := l.poller.modWrite(c.fd)
+	c.mu.Unlock()
+
+	if err != nil {
+		l.close(c, err)
+	}
+}
+
+func (l *eventLoop) close(c *EventConn, reason error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.out = nil
+	c.mu.Unlock()
+
+	l.mu.Lock()
+	delete(l.conns, c.fd)
+	l.mu.Unlock()
+
+	l.poller.del(c.fd)
+	err := syscall.Close(c.fd)
+	l.el.stats.connsInc()
+	if err != nil {
+		l.el.stats.closeErrorsInc()
+	} else {
+		l.el.stats.finClosesInc()
+	}
+
+	if l.el.cfg.OnClose != nil {
+		l.el.cfg.OnClose(c, reason)
+	}
+}
+
+func (l *eventLoop) closeAll() {
+	l.mu.Lock()
+	for _, c := range l.pending {
+		if !c.opened {
+			c.opened = true
+			syscall.Close(c.fd)
+		}
+	}
+	l.pending = nil
+	conns := make([]*EventConn, 0, len(l.conns))
+	for _, c := range l.conns {
+		conns = append(conns, c)
+	}
+	l.mu.Unlock()
+
+	for _, c := range conns {
+		l.close(c, net.ErrClosed)
+	}
+}
+
+// ErrEventConnClosed is returned by EventConn.Write after the connection is closed.
+var ErrEventConnClosed = errors.New("netx: event conn closed")
+
+// EventConn is a connection served by EventLoop.
+//
+// Methods are safe to call from any goroutine.
+type EventConn struct {
+	fd     int
+	loop   *eventLoop
+	remote net.Addr
+	opened bool // accessed only by the loop
+
+	mu       sync.Mutex
+	out      []byte // not yet written bytes
+	writing  bool   // poller waits for writability
+	draining bool   // closed by the peer, closed after out is written
+	closing  bool
+	closed   bool
+	userData interface{}
+}
+
+// Write writes b to the connection, bytes that cannot be written
+// without blocking are buffered and written by the loop.
+func (c *EventConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.closing {
+		return 0, ErrEventConnClosed
+	}
+	if len(c.out) > 0 {
+		c.out = append(c.out, b...)
+		return len(b), nil
+	}
+
+	n, err := c.write(b)
+	if err != nil {
+		return n, err
+	}
+	if n < len(b) {
+		c.out = append(c.out, b[n:]...)
+		if err := c.waitWritable(true); err != nil {
+			return n, err
+		}
+	}
+	return len(b), nil
+}
+
+// flush writes the buffered bytes, called by the loop.
+func (c *EventConn) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.out) > 0 {
+		n, err := c.write(c.out)
+		if err != nil {
+			return err
+		}
+		c.out = c.out[n:]
+	}
+	if len(c.out) == 0 {
+		c.out = nil
+		if c.draining {
+			return nil
+		}
+		return c.waitWritable(false)
+	}
+	return nil
+}
+
+// drained reports whether the connection closed by the peer has written all the bytes.
+func (c *EventConn) drained() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.draining && len(c.out) == 0
+}
+
+// write writes without blocking, c.mu must be held.
+func (c *EventConn) write(b []byte) (int, error) {
+	var total int
+	for total < len(b) {
+		n, err := syscall.Write(c.fd, b[total:])
+		if n > 0 {
+			total += n
+		}
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err == syscall.EAGAIN:
+			c.loop.el.stats.writeDone(c.loop.shard, int64(total), nil)
+			return total, nil
+		case err != nil:
+			err = &net.OpError{Op: "write", Net: "tcp", Source: c.loop.el.addr, Addr: c.remote, Err: newError("write", err)}
+			c.loop.el.stats.writeDone(c.loop.shard, int64(total), err)
+			return total, err
+		}
+	}
+	c.loop.el.stats.writeDone(c.loop.shard, int64(total), nil)
+	return total, nil
+}
+
+// waitWritable toggles the write interest of the poller, c.mu must be held.
+func (c *EventConn) waitWritable(on bool) error {
+	if c.writing == on {
+		return nil
+	}
+	c.writing = on
+	if on {
+		return c.loop.poller.modReadWrite(c.fd)
+	}
+	return c.loop.poller.modRead(c.fd)
+}
+
+// Close closes the connection from the loop, OnClose is called with nil error.
+// Buffered bytes that are not written yet are dropped,
+// unlike when the peer closes the connection.
+func (c *EventConn) Close() error {
+	c.mu.Lock()
+	if c.closed || c.closing {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closing = true
+	c.mu.Unlock()
+
+	c.loop.schedule(c)
+	return nil
+}
+
+// LocalAddr returns the listener address.
+func (c *EventConn) LocalAddr() net.Addr { return c.loop.el.addr }
+
+// RemoteAddr returns the peer address.
+func (c *EventConn) RemoteAddr() net.Addr { return c.remote }
+
+// Context returns the value set with SetContext.
+func (c *EventConn) Context() interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userData
+}
+
+// SetContext attaches a user value to the connection.
+func (c *EventConn) SetContext(v interface{}) {
+	c.mu.Lock()
+	c.userData = v
+	c.mu.Unlock()
+}
+
+func sockaddrToTCPAddr(sa syscall.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return &net.TCPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: sa.Port}
+	case *syscall.SockaddrInet6:
+		addr := &net.TCPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: sa.Port}
+		if sa.ZoneId != 0 {
+			if iface, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				addr.Zone = iface.Name
+			}
+		}
+		return addr
+	default:
+		return &net.TCPAddr{}
+	}
+}
@@ -0,0 +1,149 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package netx
+
+import "syscall"
+
+// poller is a kqueue instance with a pipe to wake up the wait.
+type poller struct {
+	fd     int
+	wakeR  int
+	wakeW  int
+	events []syscall.Kevent_t
+}
+
+func newPoller() (*poller, error) {
+	fd, err := syscall.Kqueue()
+	if err != nil {
+		return nil, newError("kqueue", err)
+	}
+	syscall.CloseOnExec(fd)
+
+	var pipe [2]int
+	syscall.ForkLock.RLock()
+	err = syscall.Pipe(pipe[:])
+	if err == nil {
+		syscall.CloseOnExec(pipe[0])
+		syscall.CloseOnExec(pipe[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		syscall.Close(fd)
+		return nil, newError("pipe", err)
+	}
+
+	p := &poller{
+		fd:     fd,
+		wakeR:  pipe[0],
+		wakeW:  pipe[1],
+		events: make([]syscall.Kevent_t, 128),
+	}
+	for _, wfd := range pipe {
+		if err := newError("setnonblock", syscall.SetNonblock(wfd, true)); err != nil {
+			p.close()
+			return nil, err
+		}
+	}
+	if err := p.addRead(p.wakeR); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *poller) addRead(fd int) error {
+	return p.ctl(fd, syscall.EVFILT_READ, syscall.EV_ADD)
+}
+
+func (p *poller) modRead(fd int) error {
+	return p.ctl(fd, syscall.EVFILT_WRITE, syscall.EV_DELETE)
+}
+
+func (p *poller) modReadWrite(fd int) error {
+	return p.ctl(fd, syscall.EVFILT_WRITE, syscall.EV_ADD)
+}
+
+func (p *poller) modWrite(fd int) error {
+	if err := p.ctl(fd, syscall.EVFILT_READ, syscall.EV_DELETE); err != nil {
+		return err
+	}
+	return p.ctl(fd, syscall.EVFILT_WRITE, syscall.EV_ADD)
+}
+
+func (p *poller) delRead(fd int) error {
+	return p.ctl(fd, syscall.EVFILT_READ, syscall.EV_DELETE)
+}
+
+// del is a no-op, kqueue drops the events of a closed fd.
+func (p *poller) del(fd int) error {
+	return nil
+}
+
+func (p *poller) ctl(fd, filter, flags int) error {
+	var ev [1]syscall.Kevent_t
+	syscall.SetKevent(&ev[0], fd, filter, flags)
+	_, err := syscall.Kevent(p.fd, ev[:], nil, nil)
+	return newError("kevent", err)
+}
+
+// wait waits for events and calls fn for each ready fd, wake ups are consumed.
+func (p *poller) wait(fn func(fd int, readable, writable bool)) error {
+	n, err := syscall.Kevent(p.fd, nil, p.events, nil)
+	if err == syscall.EINTR {
+		return nil
+	}
+	if err != nil {
+		return newError("kevent", err)
+	}
+
+	for _, ev := range p.events[:n] {
+		fd := int(ev.Ident)
+		if fd == p.wakeR {
+			p.drainWake()
+			continue
+		}
+		fn(fd, ev.Filter == syscall.EVFILT_READ, ev.Filter == syscall.EVFILT_WRITE)
+	}
+	return nil
+}
+
+func (p *poller) wake() error {
+	_, err := syscall.Write(p.wakeW, []byte{0})
+	if err == syscall.EAGAIN {
+		return nil // already woken
+	}
+	return newError("write", err)
+}
+
+func (p *poller) drainWake() {
+	var buf [64]byte
+	for {
+		if n, _ := syscall.Read(p.wakeR, buf[:]); n <= 0 {
+			return
+		}
+	}
+}
+
+func (p *poller) close() error {
+	syscall.Close(p.wakeR)
+	syscall.Close(p.wakeW)
+	return newError("close", syscall.Close(p.fd))
+}
+
+func acceptCloexec(fd int) (int, syscall.Sockaddr, error) {
+	syscall.ForkLock.RLock()
+	nfd, sa, err := syscall.Accept(fd)
+	if err == nil {
+		syscall.CloseOnExec(nfd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return -1, nil, err
+	}
+
+	if err := syscall.SetNonblock(nfd, true); err != nil {
+		syscall.Close(nfd)
+		return -1, nil, err
+	}
+	return nfd, sa, nil
+}
@@ -0,0 +1,117 @@
+//go:build linux
+
+package netx
+
+import "syscall"
+
+// poller is an epoll instance with a pipe to wake up the wait.
+type poller struct {
+	fd     int
+	wakeR  int
+	wakeW  int
+	events []syscall.EpollEvent
+}
+
+func newPoller() (*poller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, newError("epoll_create1", err)
+	}
+
+	var pipe [2]int
+	if err := syscall.Pipe2(pipe[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(fd)
+		return nil, newError("pipe2", err)
+	}
+
+	p := &poller{
+		fd:     fd,
+		wakeR:  pipe[0],
+		wakeW:  pipe[1],
+		events: make([]syscall.EpollEvent, 128),
+	}
+	if err := p.addRead(p.wakeR); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *poller) addRead(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_ADD, fd, syscall.EPOLLIN|syscall.EPOLLRDHUP)
+}
+
+func (p *poller) modRead(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_MOD, fd, syscall.EPOLLIN|syscall.EPOLLRDHUP)
+}
+
+func (p *poller) modReadWrite(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_MOD, fd, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLOUT)
+}
+
+func (p *poller) modWrite(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_MOD, fd, syscall.EPOLLOUT)
+}
+
+func (p *poller) delRead(fd int) error {
+	return p.del(fd)
+}
+
+func (p *poller) del(fd int) error {
+	return newError("epoll_ctl", syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, fd, nil))
+}
+
+func (p *poller) ctl(op, fd int, events uint32) error {
+	ev := syscall.EpollEvent{Events: events, Fd: int32(fd)}
+	return newError("epoll_ctl", syscall.EpollCtl(p.fd, op, fd, &ev))
+}
+
+// wait waits for events and calls fn for each ready fd, wake ups are consumed.
+func (p *poller) wait(fn func(fd int, readable, writable bool)) error {
+	n, err := syscall.EpollWait(p.fd, p.events, -1)
+	if err == syscall.EINTR {
+		return nil
+	}
+	if err != nil {
+		return newError("epoll_wait", err)
+	}
+
+	for _, ev := range p.events[:n] {
+		fd := int(ev.Fd)
+		if fd == p.wakeR {
+			p.drainWake()
+			continue
+		}
+		readable := ev.Events&(syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0
+		writable := ev.Events&(syscall.EPOLLOUT|syscall.EPOLLHUP|syscall.EPOLLERR) != 0
+		fn(fd, readable, writable)
+	}
+	return nil
+}
+
+func (p *poller) wake() error {
+	_, err := syscall.Write(p.wakeW, []byte{0})
+	if err == syscall.EAGAIN {
+		return nil // already woken
+	}
+	return newError("write", err)
+}
+
+func (p *poller) drainWake() {
+	var buf [64]byte
+	for {
+		if n, _ := syscall.Read(p.wakeR, buf[:]); n <= 0 {
+			return
+		}
+	}
+}
+
+func (p *poller) close() error {
+	syscall.Close(p.wakeR)
+	syscall.Close(p.wakeW)
+	return newError("close", syscall.Close(p.fd))
+}
+
+func acceptCloexec(fd int) (int, syscall.Sockaddr, error) {
+	return syscall.Accept4(fd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+}
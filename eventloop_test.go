@@ -0,0 +1,97 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package netx
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEventLoop(t *testing.T) {
+	closedCh := make(chan error, 1)
+	cfg := EventLoopConfig{
+		Loops: 2,
+		OnOpen: func(c *EventConn) {
+			c.Write([]byte("hello "))
+		},
+		OnData: func(c *EventConn, data []byte) {
+			c.Write(data)
+			c.Close()
+		},
+		OnClose: func(c *EventConn, err error) {
+			closedCh <- err
+		},
+	}
+	el, err := NewEventLoop(context.Background(), "tcp4", "127.0.0.1:0", cfg)
+	failIfErr(t, err, "cannot create event loop: %s", err)
+
+	serveCh := make(chan error, 1)
+	go func() { serveCh <- el.Serve() }()
+
+	client, err := net.Dial("tcp4", el.Addr().String())
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("world"))
+	failIfErr(t, err, "cannot write: %s", err)
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(client)
+	failIfErr(t, err, "cannot read: %s", err)
+	if string(got) != "hello world" {
+		t.Fatalf("want %q, got %q", "hello world", got)
+	}
+
+	if err := <-closedCh; err != nil {
+		t.Fatalf("want nil close error, got %v", err)
+	}
+
+	el.Close()
+	if err := <-serveCh; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+
+	stats := el.Stats()
+	if stats.Accepts() != 1 || stats.Conns() != 1 || stats.FinCloses() != 1 {
+		t.Fatalf("want 1 accept and 1 fin close, got %d and %d", stats.Accepts(), stats.FinCloses())
+	}
+	if stats.ReadBytes() != 5 || stats.WrittenBytes() != 11 {
+		t.Fatalf("want 5 read and 11 written bytes, got %d and %d", stats.ReadBytes(), stats.WrittenBytes())
+	}
+}
+
+func TestEventLoop_CloseByPeer(t *testing.T) {
+	reply := bytes.Repeat([]byte("netx"), 4<<20)
+	cfg := EventLoopConfig{
+		Loops: 1,
+		OnData: func(c *EventConn, data []byte) {
+			c.Write(reply)
+		},
+	}
+	el, err := NewEventLoop(context.Background(), "tcp4", "127.0.0.1:0", cfg)
+	failIfErr(t, err, "cannot create event loop: %s", err)
+	defer el.Close()
+	go el.Serve()
+
+	client, err := net.Dial("tcp4", el.Addr().String())
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("x"))
+	failIfErr(t, err, "cannot write: %s", err)
+	err = client.(*net.TCPConn).CloseWrite()
+	failIfErr(t, err, "cannot close write: %s", err)
+
+	// the reply buffered after EOF is written before the close
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(client)
+	failIfErr(t, err, "cannot read: %s", err)
+	if len(got) != len(reply) {
+		t.Fatalf("want %d bytes, got %d", len(reply), len(got))
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/cristalhq/netx"
 )
 
-func ExampleTCP() {
+func ExampleNewTCPListener() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
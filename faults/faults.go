@@ -0,0 +1,305 @@
+// Package faults injects network failures into connections for resilience testing.
+//
+// Every fault follows a schedule driven by a seeded random source,
+// the same seed and the same sequence of calls give the same faults.
+package faults
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cristalhq/netx"
+)
+
+// Config of the faults for one direction of a connection.
+// Probabilities are checked on every Read or Write call.
+type Config struct {
+	// Latency added to every call.
+	Latency time.Duration
+	// Jitter is a random extra latency in [0, Jitter).
+	Jitter time.Duration
+
+	// BytesPerSecond limits the bandwidth, 0 is unlimited.
+	BytesPerSecond int
+
+	// ShortProb is a probability to transfer only a part of the buffer.
+	// Short writes return io.ErrShortWrite.
+	ShortProb float64
+	// ResetProb is a probability to reset the connection.
+	ResetProb float64
+	// StallProb is a probability to block until the deadline or close.
+	StallProb float64
+	// CorruptProb is a probability to flip a random bit of the transferred data.
+	CorruptProb float64
+}
+
+// Options of the faults.
+type Options struct {
+	// Seed of the faults schedule.
+	Seed int64
+
+	Read  Config
+	Write Config
+}
+
+// Conn is a connection with injected faults.
+type Conn struct {
+	net.Conn
+	read  direction
+	write direction
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+type direction struct {
+	cfg Config
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// Wrap returns conn with injected faults.
+// It works with any net.Conn, reset aborts *netx.Conn and *net.TCPConn with RST.
+func Wrap(conn net.Conn, opts Options) *Conn {
+	return &Conn{
+		Conn: conn,
+		read: direction{
+			cfg: opts.Read,
+			rnd: rand.New(rand.NewSource(opts.Seed)),
+		},
+		write: direction{
+			cfg: opts.Write,
+			rnd: rand.New(rand.NewSource(opts.Seed + 1)),
+		},
+		closeCh: make(chan struct{}),
+	}
+}
+
+// Read reads data from the connection applying read faults.
+func (c *Conn) Read(b []byte) (int, error) {
+	f := c.read.next(len(b))
+	if err := c.apply(f, c.deadline(&c.readDeadline), "read"); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(b[:f.size])
+	if f.corrupt && n > 0 {
+		b[f.pos%n] ^= 1 << f.bit
+	}
+	c.throttle(c.read.cfg, n)
+	return n, err
+}
+
+// Write writes data to the connection applying write faults.
+func (c *Conn) Write(b []byte) (int, error) {
+	f := c.write.next(len(b))
+	if err := c.apply(f, c.deadline(&c.writeDeadline), "write"); err != nil {
+		return 0, err
+	}
+
+	p := b[:f.size]
+	if f.corrupt && len(p) > 0 {
+		p = append([]byte(nil), p...)
+		p[f.pos%len(p)] ^= 1 << f.bit
+	}
+
+	n, err := c.Conn.Write(p)
+	c.throttle(c.write.cfg, n)
+	if err == nil && n < len(b) {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+// Close closes the connection and unblocks stalled calls.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closeCh) })
+	return c.Conn.Close()
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// fault is a scheduled fault of a single call.
+type fault struct {
+	delay   time.Duration
+	size    int
+	reset   bool
+	stall   bool
+	corrupt bool
+	pos     int
+	bit     uint
+}
+
+// next returns faults of the next call with a buffer of n bytes.
+func (d *direction) next(n int) fault {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f := fault{
+		delay: d.cfg.Latency,
+		size:  n,
+	}
+	if d.cfg.Jitter > 0 {
+		f.delay += time.Duration(d.rnd.Int63n(int64(d.cfg.Jitter)))
+	}
+	if d.hit(d.cfg.ShortProb) && n > 1 {
+		f.size = 1 + d.rnd.Intn(n-1)
+	}
+	f.reset = d.hit(d.cfg.ResetProb)
+	f.stall = d.hit(d.cfg.StallProb)
+	if d.hit(d.cfg.CorruptProb) {
+		f.corrupt = true
+		f.pos = d.rnd.Intn(1 << 30)
+		f.bit = uint(d.rnd.Intn(8))
+	}
+	return f
+}
+
+func (d *direction) hit(prob float64) bool {
+	// always draw a number to keep the schedule independent of the config
+	v := d.rnd.Float64()
+	return prob > 0 && v < prob
+}
+
+func (c *Conn) apply(f fault, deadline time.Time, op string) error {
+	if f.delay > 0 {
+		if err := c.sleep(f.delay, deadline); err != nil {
+			return c.opError(op, err)
+		}
+	}
+
+	switch {
+	case f.reset:
+		c.reset()
+		return c.opError(op, syscall.ECONNRESET)
+	case f.stall:
+		return c.opError(op, c.sleep(forever, deadline))
+	}
+	return nil
+}
+
+const forever = time.Duration(1<<63 - 1)
+
+// sleep waits for d unless the deadline is exceeded or the conn is closed.
+func (c *Conn) sleep(d time.Duration, deadline time.Time) error {
+	var deadlineCh <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		deadlineCh = t.C
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-deadlineCh:
+		return os.ErrDeadlineExceeded
+	case <-c.closeCh:
+		return net.ErrClosed
+	}
+}
+
+func (c *Conn) throttle(cfg Config, n int) {
+	if cfg.BytesPerSecond <= 0 || n <= 0 {
+		return
+	}
+	d := time.Duration(n) * time.Second / time.Duration(cfg.BytesPerSecond)
+	c.sleep(d, time.Time{})
+}
+
+func (c *Conn) reset() {
+	switch conn := c.Conn.(type) {
+	case *netx.Conn:
+		conn.Abort()
+	case *net.TCPConn:
+		conn.SetLinger(0)
+		conn.Close()
+	default:
+		conn.Close()
+	}
+	c.closeOnce.Do(func() { close(c.closeCh) })
+}
+
+func (c *Conn) deadline(t *time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *t
+}
+
+func (c *Conn) opError(op string, err error) error {
+	return &net.OpError{
+		Op:     op,
+		Net:    c.LocalAddr().Network(),
+		Source: c.LocalAddr(),
+		Addr:   c.RemoteAddr(),
+		Err:    err,
+	}
+}
+
+// Listener applies faults to the accepted connections.
+type Listener struct {
+	net.Listener
+	opts Options
+
+	mu    sync.Mutex
+	count int64
+}
+
+// NewListener returns a listener that wraps accepted connections with faults.
+// The i-th accepted connection uses Seed+2*i as its seed.
+func NewListener(ln net.Listener, opts Options) *Listener {
+	return &Listener{
+		Listener: ln,
+		opts:     opts,
+	}
+}
+
+// Accept waits for and returns the next connection with injected faults.
+func (ln *Listener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	ln.mu.Lock()
+	opts := ln.opts
+	opts.Seed += 2 * ln.count
+	ln.count++
+	ln.mu.Unlock()
+
+	return Wrap(conn, opts), nil
+}
@@ -0,0 +1,90 @@
+package faults
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cristalhq/netx"
+)
+
+func TestConn_Reproducible(t *testing.T) {
+	opts := Options{
+		Seed: 42,
+		Read: Config{ShortProb: 0.5, CorruptProb: 0.3},
+	}
+	first := readThrough(t, opts)
+	second := readThrough(t, opts)
+
+	if !bytes.Equal(first, second) {
+		t.Fatal("same seed must give same faults")
+	}
+	if bytes.Equal(first, bytes.Repeat([]byte("netx"), 256)) {
+		t.Fatal("want corrupted data")
+	}
+}
+
+func TestConn_Reset(t *testing.T) {
+	client, server := newPair(t)
+	conn := Wrap(client, Options{Write: Config{ResetProb: 1}})
+
+	_, err := conn.Write([]byte("hello"))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("want connection reset, got %v", err)
+	}
+
+	_, err = io.ReadAll(server)
+	if err != nil {
+		t.Fatalf("want EOF on the server, got %v", err)
+	}
+}
+
+func TestConn_Stall(t *testing.T) {
+	client, _ := newPair(t)
+	conn := Wrap(client, Options{Read: Config{StallProb: 1}})
+
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(50 * time.Millisecond))
+
+	_, err := conn.Read(make([]byte, 8))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("read must stall until the deadline")
+	}
+}
+
+func readThrough(tb testing.TB, opts Options) []byte {
+	client, server := newPair(tb)
+	go func() {
+		server.Write(bytes.Repeat([]byte("netx"), 256))
+		server.Close()
+	}()
+
+	got, err := io.ReadAll(Wrap(client, opts))
+	if err != nil {
+		tb.Fatalf("cannot read: %s", err)
+	}
+	return got
+}
+
+func newPair(tb testing.TB) (*netx.MemConn, *netx.MemConn) {
+	ln := netx.NewMemListener(netx.MemListenerConfig{})
+	tb.Cleanup(func() { ln.Close() })
+
+	client, err := ln.Dial(context.Background())
+	if err != nil {
+		tb.Fatalf("cannot dial: %s", err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		tb.Fatalf("cannot accept: %s", err)
+	}
+	return client, server.(*netx.MemConn)
+}
@@ -0,0 +1,330 @@
+// Package frame reads and writes length-prefixed or delimited messages over connections.
+package frame
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync/atomic"
+
+	"github.com/cristalhq/netx"
+)
+
+// Format of the message framing.
+type Format int
+
+const (
+	// Uint16 prefixes a message with its big-endian uint16 length.
+	Uint16 Format = iota + 1
+	// Uint32 prefixes a message with its big-endian uint32 length.
+	Uint32
+	// Varint prefixes a message with its unsigned varint length.
+	Varint
+	// Delimited terminates a message with Config.Delimiter.
+	Delimited
+)
+
+var (
+	// ErrTooLarge is returned for messages larger than Config.MaxSize.
+	// After a read error the stream is out of sync and must be closed.
+	ErrTooLarge = errors.New("frame: message too large")
+
+	// ErrDelimiter is returned when a written message contains the delimiter.
+	ErrDelimiter = errors.New("frame: message contains delimiter")
+)
+
+// Config of a Framer.
+type Config struct {
+	Format Format
+
+	// Delimiter of Delimited messages (default '\n').
+	Delimiter byte
+
+	// MaxSize of a message in bytes (default 1MB).
+	MaxSize int
+
+	// Stats to gather message stats, may be shared by many framers.
+	// If nil framer has its own Stats.
+	Stats *Stats
+}
+
+// Framer reads and writes messages over a connection.
+// Reads and writes may run concurrently, but not reads with reads
+// and writes with writes.
+type Framer struct {
+	conn  io.ReadWriter
+	cfg   Config
+	cr    *ctxReader
+	br    *bufio.Reader
+	stats *Stats
+}
+
+// NewFramer returns new Framer over the connection.
+// Context-aware methods require conn to implement netx.CtxConn.
+func NewFramer(conn io.ReadWriter, cfg Config) (*Framer, error) {
+	switch cfg.Format {
+	case Uint16, Uint32, Varint, Delimited:
+	default:
+		return nil, fmt.Errorf("frame: unknown format %d", cfg.Format)
+	}
+	if cfg.Delimiter == 0 {
+		cfg.Delimiter = '\n'
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 1 << 20
+	}
+	if cfg.Format == Uint16 && cfg.MaxSize > 1<<16-1 {
+		cfg.MaxSize = 1<<16 - 1
+	}
+	if cfg.Stats == nil {
+		cfg.Stats = &Stats{}
+	}
+
+	cr := &ctxReader{conn: conn}
+	f := &Framer{
+		conn:  conn,
+		cfg:   cfg,
+		cr:    cr,
+		br:    bufio.NewReader(cr),
+		stats: cfg.Stats,
+	}
+	return f, nil
+}
+
+// Stats of the framer messages.
+func (f *Framer) Stats() *Stats {
+	return f.stats
+}
+
+// ReadMessage reads the next message.
+// The returned slice can be passed to Release after use.
+func (f *Framer) ReadMessage() ([]byte, error) {
+	return f.read()
+}
+
+// ReadMessageContext does same as ReadMessage but with a context.
+// After a context error the stream is out of sync and must be closed.
+func (f *Framer) ReadMessageContext(ctx context.Context) ([]byte, error) {
+	f.cr.ctx = ctx
+	defer func() { f.cr.ctx = nil }()
+	return f.read()
+}
+
+func (f *Framer) read() ([]byte, error) {
+	var msg []byte
+	var err error
+	if f.cfg.Format == Delimited {
+		msg, err = f.readDelimited()
+	} else {
+		msg, err = f.readPrefixed()
+	}
+	if err != nil {
+		if err == ErrTooLarge {
+			f.stats.oversizeInc()
+		}
+		return nil, err
+	}
+	f.stats.messageIn(len(msg))
+	return msg, nil
+}
+
+// WriteMessage writes the message.
+func (f *Framer) WriteMessage(msg []byte) error {
+	buf, err := f.encode(msg)
+	if err != nil {
+		return err
+	}
+	defer Release(buf)
+
+	_, err = f.conn.Write(buf)
+	return f.written(msg, err)
+}
+
+// WriteMessageContext does same as WriteMessage but with a context.
+// If the connection isn't a netx.CtxConn the context is ignored.
+func (f *Framer) WriteMessageContext(ctx context.Context, msg []byte) error {
+	cc, ok := f.conn.(netx.CtxConn)
+	if !ok {
+		return f.WriteMessage(msg)
+	}
+
+	buf, err := f.encode(msg)
+	if err != nil {
+		return err
+	}
+	defer Release(buf)
+
+	_, err = cc.WriteContext(ctx, buf)
+	return f.written(msg, err)
+}
+
+// encode returns the framed message in a pooled buffer.
+func (f *Framer) encode(msg []byte) ([]byte, error) {
+	if len(msg) > f.cfg.MaxSize {
+		f.stats.oversizeInc()
+		return nil, ErrTooLarge
+	}
+	if f.cfg.Format == Delimited && bytes.IndexByte(msg, f.cfg.Delimiter) >= 0 {
+		return nil, ErrDelimiter
+	}
+
+	buf := netx.DefaultBufferPool.Get(len(msg) + binary.MaxVarintLen64)
+	var n int
+	switch f.cfg.Format {
+	case Uint16:
+		binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+		n = 2
+	case Uint32:
+		binary.BigEndian.PutUint32(buf, uint32(len(msg)))
+		n = 4
+	case Varint:
+		n = binary.PutUvarint(buf, uint64(len(msg)))
+	}
+	n += copy(buf[n:], msg)
+	if f.cfg.Format == Delimited {
+		buf[n] = f.cfg.Delimiter
+		n++
+	}
+	return buf[:n], nil
+}
+
+func (f *Framer) written(msg []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	f.stats.messageOut(len(msg))
+	return nil
+}
+
+func (f *Framer) readPrefixed() ([]byte, error) {
+	var size uint64
+	switch f.cfg.Format {
+	case Uint16, Uint32:
+		header := make([]byte, 4)
+		if f.cfg.Format == Uint16 {
+			header = header[:2]
+		}
+		if _, err := io.ReadFull(f.br, header); err != nil {
+			return nil, err
+		}
+		if len(header) == 2 {
+			size = uint64(binary.BigEndian.Uint16(header))
+		} else {
+			size = uint64(binary.BigEndian.Uint32(header))
+		}
+	case Varint:
+		var err error
+		if size, err = binary.ReadUvarint(f.br); err != nil {
+			return nil, err
+		}
+	}
+
+	if size > uint64(f.cfg.MaxSize) {
+		return nil, ErrTooLarge
+	}
+
+	msg := netx.DefaultBufferPool.Get(int(size))[:size]
+	if _, err := io.ReadFull(f.br, msg); err != nil {
+		Release(msg)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (f *Framer) readDelimited() ([]byte, error) {
+	var msg []byte
+	for {
+		line, err := f.br.ReadSlice(f.cfg.Delimiter)
+		if len(msg)+len(line) > f.cfg.MaxSize+1 {
+			Release(msg)
+			return nil, ErrTooLarge
+		}
+		if msg == nil && err == nil {
+			msg = netx.DefaultBufferPool.Get(len(line))[:0]
+		}
+		msg = append(msg, line...)
+
+		switch {
+		case err == nil:
+			return msg[:len(msg)-1], nil
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && len(msg) > 0:
+			Release(msg)
+			return nil, io.ErrUnexpectedEOF
+		default:
+			Release(msg)
+			return nil, err
+		}
+	}
+}
+
+// ctxReader reads with the context of the current ReadMessageContext call,
+// nil ctx means a plain Read.
+type ctxReader struct {
+	conn io.Reader
+	ctx  context.Context
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if cc, ok := r.conn.(netx.CtxConn); ok && r.ctx != nil {
+		return cc.ReadContext(r.ctx, p)
+	}
+	return r.conn.Read(p)
+}
+
+// Stats of the messages.
+type Stats struct {
+	messagesIn  uint64
+	messagesOut uint64
+	oversize    uint64
+	sizes       [32]uint64
+}
+
+func (s *Stats) MessagesIn() uint64  { return atomic.LoadUint64(&s.messagesIn) }
+func (s *Stats) MessagesOut() uint64 { return atomic.LoadUint64(&s.messagesOut) }
+func (s *Stats) Oversize() uint64    { return atomic.LoadUint64(&s.oversize) }
+
+// SizeHistogram of the read and written messages in bytes.
+//
+// Bucket 0 counts zeros, bucket i counts values in [2^(i-1), 2^i).
+func (s *Stats) SizeHistogram() []uint64 {
+	res := make([]uint64, len(s.sizes))
+	for i := range s.sizes {
+		res[i] = atomic.LoadUint64(&s.sizes[i])
+	}
+	return res
+}
+
+func (s *Stats) messageIn(size int) {
+	atomic.AddUint64(&s.messagesIn, 1)
+	s.sizeObserve(size)
+}
+
+func (s *Stats) messageOut(size int) {
+	atomic.AddUint64(&s.messagesOut, 1)
+	s.sizeObserve(size)
+}
+
+func (s *Stats) oversizeInc() { atomic.AddUint64(&s.oversize, 1) }
+
+func (s *Stats) sizeObserve(size int) {
+	i := bits.Len64(uint64(size))
+	if i >= len(s.sizes) {
+		i = len(s.sizes) - 1
+	}
+	atomic.AddUint64(&s.sizes[i], 1)
+}
+
+// Release returns a message buffer to netx.DefaultBufferPool, msg must not be used after.
+func Release(msg []byte) {
+	netx.DefaultBufferPool.Put(msg)
+}
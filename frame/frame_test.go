@@ -0,0 +1,95 @@
+package frame
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cristalhq/netx"
+)
+
+func TestFramer(t *testing.T) {
+	for _, format := range []Format{Uint16, Uint32, Varint, Delimited} {
+		client, server := newPair(t)
+		stats := &Stats{}
+		cfg := Config{Format: format, MaxSize: 1024, Stats: stats}
+
+		w, err := NewFramer(client, cfg)
+		failIfErr(t, err)
+		r, err := NewFramer(server, cfg)
+		failIfErr(t, err)
+
+		msgs := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte("x"), 1000)}
+		go func() {
+			for _, msg := range msgs {
+				w.WriteMessage(msg)
+			}
+		}()
+
+		for i, want := range msgs {
+			got, err := r.ReadMessage()
+			failIfErr(t, err)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("format %d, message %d: want %q, got %q", format, i, want, got)
+			}
+			Release(got)
+		}
+
+		if err := w.WriteMessage(make([]byte, 1025)); err != ErrTooLarge {
+			t.Fatalf("format %d: want too large, got %v", format, err)
+		}
+		if stats.MessagesIn() != 3 || stats.MessagesOut() != 3 || stats.Oversize() != 1 {
+			t.Fatalf("format %d: unexpected stats in=%d out=%d oversize=%d",
+				format, stats.MessagesIn(), stats.MessagesOut(), stats.Oversize())
+		}
+	}
+}
+
+func TestFramer_ReadTooLarge(t *testing.T) {
+	client, server := newPair(t)
+
+	w, err := NewFramer(client, Config{Format: Varint})
+	failIfErr(t, err)
+	r, err := NewFramer(server, Config{Format: Varint, MaxSize: 8})
+	failIfErr(t, err)
+
+	go w.WriteMessage([]byte("too large message"))
+
+	if _, err := r.ReadMessage(); err != ErrTooLarge {
+		t.Fatalf("want too large, got %v", err)
+	}
+}
+
+func TestFramer_ReadMessageContext(t *testing.T) {
+	_, server := newPair(t)
+
+	r, err := NewFramer(server, Config{Format: Uint32})
+	failIfErr(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := r.ReadMessageContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+}
+
+func newPair(tb testing.TB) (*netx.MemConn, *netx.MemConn) {
+	ln := netx.NewMemListener(netx.MemListenerConfig{})
+	tb.Cleanup(func() { ln.Close() })
+
+	client, err := ln.Dial(context.Background())
+	failIfErr(tb, err)
+	server, err := ln.Accept()
+	failIfErr(tb, err)
+	return client, server.(*netx.MemConn)
+}
+
+func failIfErr(tb testing.TB, err error) {
+	tb.Helper()
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
@@ -0,0 +1,330 @@
+package netx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPProxyError is a non-success response of an HTTP proxy to CONNECT.
+type HTTPProxyError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPProxyError) Error() string {
+	return "netx: http proxy CONNECT failed: " + e.Status
+}
+
+var errHTTPProxyResponse = errors.New("netx: http proxy response is too large")
+
+// HTTPProxyDialerConfig is a config for HTTPProxyDialer.
+type HTTPProxyDialerConfig struct {
+	// Proxy is the proxy URL with "http" or "https" scheme,
+	// if nil HTTPS_PROXY and NO_PROXY environment variables are used.
+	Proxy *url.URL
+
+	// Username and Password enable basic authentication,
+	// the user info of the proxy URL is used if they are empty.
+	Username string
+	Password string
+
+	// BearerToken enables bearer authentication.
+	BearerToken string
+
+	// Header is added to CONNECT requests.
+	Header http.Header
+
+	// TLSConfig is used for "https" proxies.
+	TLSConfig *tls.Config
+
+	// Dial dials the proxy and the destinations bypassing it (default Dialer with "tcp" network).
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+
+	// HandshakeTimeout limits the dial of the proxy and the handshakes
+	// in addition to the context deadline (default 10s).
+	HandshakeTimeout time.Duration
+}
+
+// HTTPProxyDialer dials TCP connections through an HTTP CONNECT proxy.
+//
+// Connections are instrumented with the dialer Stats.
+type HTTPProxyDialer struct {
+	cfg     HTTPProxyDialerConfig
+	proxy   *url.URL
+	noProxy []string
+	stats   *Stats
+}
+
+// NewHTTPProxyDialer returns new HTTPProxyDialer.
+func NewHTTPProxyDialer(cfg HTTPProxyDialerConfig) (*HTTPProxyDialer, error) {
+	if cfg.Dial == nil {
+		var d Dialer
+		cfg.Dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = 10 * time.Second
+	}
+
+	d := &HTTPProxyDialer{
+		cfg:   cfg,
+		proxy: cfg.Proxy,
+		stats: &Stats{},
+	}
+	if d.proxy == nil {
+		proxy := getEnvAny("HTTPS_PROXY", "https_proxy")
+		if proxy != "" {
+			u, err := parseProxyURL(proxy)
+			if err != nil {
+				return nil, err
+			}
+			d.proxy = u
+		}
+		d.noProxy = parseNoProxy(getEnvAny("NO_PROXY", "no_proxy"))
+	}
+
+	if d.proxy != nil && d.proxy.Scheme != "http" && d.proxy.Scheme != "https" {
+		return nil, errors.New("netx: unsupported proxy scheme " + strconv.Quote(d.proxy.Scheme))
+	}
+	return d, nil
+}
+
+// Stats of the dialed connections.
+func (d *HTTPProxyDialer) Stats() *Stats {
+	return d.stats
+}
+
+// Dial connects to addr through the proxy.
+func (d *HTTPProxyDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr through the proxy using the provided context.
+// The context bounds the dial and the handshakes only.
+func (d *HTTPProxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	if d.proxy == nil || matchNoProxy(d.noProxy, host, port) {
+		conn, err := d.cfg.Dial(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		return instrumentConn(conn, d.stats), nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.HandshakeTimeout)
+	defer cancel()
+
+	conn, err := d.cfg.Dial(ctx, proxyHostPort(d.proxy))
+	if err != nil {
+		return nil, err
+	}
+
+	stopCh := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(aLongTimeAgo)
+		case <-stopCh:
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	conn, err = d.handshake(conn, addr)
+	close(stopCh)
+
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: &hostAddr{network: network, host: host, port: port}, Err: err}
+	}
+	conn.SetDeadline(time.Time{})
+	return instrumentConn(conn, d.stats), nil
+}
+
+func (d *HTTPProxyDialer) handshake(conn net.Conn, addr string) (net.Conn, error) {
+	if d.proxy.Scheme == "https" {
+		cfg := d.cfg.TLSConfig.Clone()
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = d.proxy.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.Handshake(); err != nil {
+			return conn, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	for k, v := range d.cfg.Header {
+		req.Header[k] = v
+	}
+	if auth := d.authorization(); auth != "" {
+		req.Header.Set("Proxy-Authorization", auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+
+	// the response is read byte by byte to leave the tunneled data in conn
+	head, err := readResponseHead(conn)
+	if err != nil {
+		return conn, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), req)
+	if err != nil {
+		return conn, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return conn, &HTTPProxyError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return conn, nil
+}
+
+func (d *HTTPProxyDialer) authorization() string {
+	if d.cfg.BearerToken != "" {
+		return "Bearer " + d.cfg.BearerToken
+	}
+
+	username, password := d.cfg.Username, d.cfg.Password
+	if username == "" && d.proxy.User != nil {
+		username = d.proxy.User.Username()
+		password, _ = d.proxy.User.Password()
+	}
+	if username == "" {
+		return ""
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// readResponseHead reads up to the end of HTTP headers.
+func readResponseHead(conn net.Conn) ([]byte, error) {
+	const maxHead = 16 << 10
+
+	head := make([]byte, 0, 128)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(head, []byte("\r\n\r\n")) {
+		if len(head) >= maxHead {
+			return nil, errHTTPProxyResponse
+		}
+		n, err := conn.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		head = append(head, b[:n]...)
+	}
+	return head, nil
+}
+
+func proxyHostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func parseProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		// "proxy.example.com:3128" is treated as http
+		if u, err := url.Parse("http://" + proxy); err == nil {
+			return u, nil
+		}
+	}
+	return u, err
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func parseNoProxy(s string) []string {
+	var res []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// matchNoProxy reports whether host bypasses the proxy.
+// Entries are "*", IPs, CIDRs and domain names that match their subdomains,
+// all of them with an optional port.
+func matchNoProxy(noProxy []string, host string, port int) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+
+	for _, p := range noProxy {
+		if p == "*" {
+			return true
+		}
+		if _, ipnet, err := net.ParseCIDR(p); err == nil {
+			if ip != nil && ipnet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if h, portStr, err := net.SplitHostPort(p); err == nil {
+			if portStr != strconv.Itoa(port) {
+				continue
+			}
+			p = h
+		}
+		if pip := net.ParseIP(p); pip != nil {
+			if ip != nil && pip.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		p = strings.TrimPrefix(strings.TrimPrefix(p, "*"), ".")
+		if host == p || strings.HasSuffix(host, "."+p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPProxyDialer(t *testing.T) {
+	echo := newEchoServer(t)
+	proxy := newConnectProxy(t, nil, "Basic dXNlcjpwYXNz")
+
+	d, err := NewHTTPProxyDialer(HTTPProxyDialerConfig{
+		Proxy: &url.URL{Scheme: "http", Host: proxy, User: url.UserPassword("user", "pass")},
+	})
+	failIfErr(t, err, "cannot create dialer: %s", err)
+
+	p, err := NewConnPoolWithConfig(context.Background(), echo, 1, ConnPoolConfig{
+		Dial: func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		},
+	})
+	failIfErr(t, err, "cannot create pool: %s", err)
+	defer p.Close()
+
+	conn, err := p.Acquire(context.Background())
+	failIfErr(t, err, "cannot acquire: %s", err)
+	if _, ok := conn.(*Conn); !ok {
+		t.Fatalf("want *Conn, got %T", conn)
+	}
+	testEcho(t, conn)
+	p.Release(conn)
+
+	if got := d.Stats().ReadBytes(); got != 4 {
+		t.Fatalf("want 4 read bytes, got %d", got)
+	}
+
+	d, err = NewHTTPProxyDialer(HTTPProxyDialerConfig{
+		Proxy:       &url.URL{Scheme: "http", Host: proxy},
+		BearerToken: "token",
+	})
+	failIfErr(t, err, "cannot create dialer: %s", err)
+	_, err = d.Dial("tcp", echo)
+	var proxyErr *HTTPProxyError
+	if !errors.As(err, &proxyErr) || proxyErr.StatusCode != http.StatusProxyAuthRequired {
+		t.Fatalf("want 407, got %v", err)
+	}
+}
+
+func TestHTTPProxyDialer_TLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, 1)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	failIfErr(t, err, "cannot load certificate: %s", err)
+
+	echo := newEchoServer(t)
+	proxy := newConnectProxy(t, &tls.Config{Certificates: []tls.Certificate{cert}}, "Bearer token")
+	_, port, _ := net.SplitHostPort(proxy)
+
+	t.Setenv("HTTPS_PROXY", "https://localhost:"+port)
+	t.Setenv("NO_PROXY", "example.com")
+	d, err := NewHTTPProxyDialer(HTTPProxyDialerConfig{
+		BearerToken: "token",
+		TLSConfig:   &tls.Config{InsecureSkipVerify: true},
+	})
+	failIfErr(t, err, "cannot create dialer: %s", err)
+
+	conn, err := d.Dial("tcp", echo)
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer conn.Close()
+	testEcho(t, conn)
+
+	if got := d.Stats().WrittenBytes(); got != 4 {
+		t.Fatalf("want 4 written bytes, got %d", got)
+	}
+}
+
+func TestMatchNoProxy(t *testing.T) {
+	noProxy := parseNoProxy("example.com, .internal, 10.0.0.0/8, 192.168.1.1, localhost:8080")
+
+	testCases := []struct {
+		host string
+		port int
+		want bool
+	}{
+		{"example.com", 443, true},
+		{"api.example.com", 443, true},
+		{"notexample.com", 443, false},
+		{"db.internal", 5432, true},
+		{"10.1.2.3", 80, true},
+		{"11.1.2.3", 80, false},
+		{"192.168.1.1", 80, true},
+		{"localhost", 8080, true},
+		{"localhost", 8081, false},
+	}
+	for _, tc := range testCases {
+		if got := matchNoProxy(noProxy, tc.host, tc.port); got != tc.want {
+			t.Errorf("%s:%d: want %v, got %v", tc.host, tc.port, tc.want, got)
+		}
+	}
+	if !matchNoProxy(parseNoProxy("*"), "any.host", 1) {
+		t.Error("want * to match")
+	}
+}
+
+func testEcho(tb testing.TB, conn net.Conn) {
+	tb.Helper()
+
+	_, err := conn.Write([]byte("ping"))
+	failIfErr(tb, err, "cannot write: %s", err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	failIfErr(tb, err, "cannot read: %s", err)
+	if string(buf) != "ping" {
+		tb.Fatalf("want ping, got %q", buf)
+	}
+}
+
+// newConnectProxy returns the addr of a local HTTP CONNECT proxy closed with the test.
+func newConnectProxy(tb testing.TB, tlsConfig *tls.Config, auth string) string {
+	tb.Helper()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	failIfErr(tb, err, "cannot listen: %s", err)
+	tb.Cleanup(func() { ln.Close() })
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				if req.Header.Get("Proxy-Authorization") != auth {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
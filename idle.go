@@ -0,0 +1,129 @@
+package netx
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// idleWheelSlots is the number of ticks per idle timeout,
+// connections are closed within IdleTimeout + IdleTimeout/idleWheelSlots.
+const idleWheelSlots = 8
+
+// idleWheel is a timer wheel that closes idle connections.
+// A single goroutine serves all connections, it runs only while there are any.
+type idleWheel struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	tick    time.Duration
+	slots   []map[*Conn]struct{}
+	pos     int
+	count   int
+	running bool
+}
+
+func newIdleWheel(timeout time.Duration) *idleWheel {
+	tick := timeout / idleWheelSlots
+	if tick < time.Millisecond {
+		tick = time.Millisecond
+	}
+
+	w := &idleWheel{
+		timeout: timeout,
+		tick:    tick,
+		slots:   make([]map[*Conn]struct{}, int(timeout/tick)+2),
+	}
+	for i := range w.slots {
+		w.slots[i] = map[*Conn]struct{}{}
+	}
+	return w
+}
+
+func (w *idleWheel) add(c *Conn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	c.touch()
+	w.insert(c, w.timeout)
+	w.count++
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+}
+
+func (w *idleWheel) remove(c *Conn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if c.idleSlot < 0 {
+		return
+	}
+	delete(w.slots[c.idleSlot], c)
+	c.idleSlot = -1
+	w.count--
+}
+
+// insert puts c into the slot that fires after d, w.mu must be held.
+func (w *idleWheel) insert(c *Conn, d time.Duration) {
+	ticks := int((d + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	if ticks >= len(w.slots) {
+		ticks = len(w.slots) - 1
+	}
+	c.idleSlot = (w.pos + ticks) % len(w.slots)
+	w.slots[c.idleSlot][c] = struct{}{}
+}
+
+func (w *idleWheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !w.advance(time.Now()) {
+			return
+		}
+	}
+}
+
+// advance moves the wheel one tick and closes expired connections.
+// It returns false when the wheel is empty and run must stop.
+func (w *idleWheel) advance(now time.Time) bool {
+	w.mu.Lock()
+
+	w.pos = (w.pos + 1) % len(w.slots)
+	slot := w.slots[w.pos]
+	w.slots[w.pos] = map[*Conn]struct{}{}
+
+	var expired []*Conn
+	for c := range slot {
+		left := w.timeout - now.Sub(c.lastActive())
+		if left > 0 {
+			w.insert(c, left)
+			continue
+		}
+		c.idleSlot = -1
+		w.count--
+		expired = append(expired, c)
+	}
+
+	running := w.count > 0
+	w.running = running
+	w.mu.Unlock()
+
+	for _, c := range expired {
+		c.stats.idleClosesInc()
+		c.Close()
+	}
+	return running
+}
+
+func (c *Conn) touch() {
+	atomic.StoreInt64(&c.activeAt, time.Now().UnixNano())
+}
+
+func (c *Conn) lastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.activeAt))
+}
@@ -0,0 +1,917 @@
+//go:build linux
+
+package netx
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	sysIOURingSetup    = 425
+	sysIOURingEnter    = 426
+	sysIOURingRegister = 427
+
+	uringOffSQRing = 0
+	uringOffSQEs   = 0x10000000
+
+	uringFeatSingleMmap = 1 << 0
+
+	uringOpNop         = 0
+	uringOpAccept      = 13
+	uringOpAsyncCancel = 14
+	uringOpSend        = 26
+	uringOpRecv        = 27
+
+	uringSQEBufferSelect  = 1 << 5
+	uringAcceptMultishot  = 1 << 0
+	uringCancelAll        = 1 << 0
+	uringCancelFD         = 1 << 1
+	uringCQEFBuffer       = 1 << 0
+	uringCQEFMore         = 1 << 1
+	uringCQEBufferShift   = 16
+	uringEnterGetEvents   = 1 << 0
+	uringRegisterPbufRing = 22
+
+	uringEntries  = 4096
+	uringBufCount = 512
+	uringBufSize  = 4096
+	uringBufGroup = 0
+
+	uringWakeID   = 0       // no-op that wakes up the reactor
+	uringCancelID = 1 << 63 // completions of cancel requests are ignored
+)
+
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQOffsets
+	cqOff                                                                  uringCQOffsets
+}
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type uringBuf struct {
+	addr uint64
+	len  uint32
+	bid  uint16
+	resv uint16 // tail of the ring in the first entry
+}
+
+type uringBufReg struct {
+	ringAddr    uint64
+	ringEntries uint32
+	bgid        uint16
+	flags       uint16
+	resv        [3]uint64
+}
+
+// uring is an io_uring instance with a reactor goroutine that dispatches completions,
+// it is shared by a listener and its connections.
+//
+// Operations are submitted by the goroutine that queued them,
+// unless another goroutine is already entering the kernel:
+// then it submits them with the next batch, so sends and receives
+// of concurrent connections share io_uring_enter calls.
+type uring struct {
+	fd      int
+	ringMem []byte
+	sqeMem  []byte
+
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  uint32
+	sqArray []uint32
+	sqes    []uringSQE
+	cqHead  *uint32
+	cqTail  *uint32
+	cqMask  uint32
+	cqes    []uringCQE
+
+	bufRingMem []byte
+	bufMem     []byte
+	bufs       []uringBuf
+	bufTail    *uint16
+
+	mu       sync.Mutex
+	ops      map[uint64]func(res int32, flags uint32)
+	nextID   uint64
+	queued   uint32
+	flushing bool // a goroutine is submitting the queued entries
+	refs     int
+}
+
+func newURing() (r *uring, err error) {
+	var p uringParams
+	fd, _, errno := syscall.Syscall(sysIOURingSetup, uringEntries, uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, newError("io_uring_setup", errno)
+	}
+	r = &uring{fd: int(fd), ops: map[uint64]func(int32, uint32){}, nextID: 1, refs: 1}
+	defer func() {
+		if err != nil {
+			r.free()
+		}
+	}()
+
+	if p.features&uringFeatSingleMmap == 0 {
+		return nil, ErrNotSupported
+	}
+
+	size := p.sqOff.array + p.sqEntries*4
+	if cqSize := p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(uringCQE{})); cqSize > size {
+		size = cqSize
+	}
+	if r.ringMem, err = syscall.Mmap(r.fd, uringOffSQRing, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE); err != nil {
+		return nil, newError("mmap", err)
+	}
+	sqeSize := int(p.sqEntries) * int(unsafe.Sizeof(uringSQE{}))
+	if r.sqeMem, err = syscall.Mmap(r.fd, uringOffSQEs, sqeSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE); err != nil {
+		return nil, newError("mmap", err)
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.ringMem[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.ringMem[p.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.ringMem[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.ringMem[p.sqOff.array])), p.sqEntries)
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&r.sqeMem[0])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.ringMem[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.ringMem[p.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.ringMem[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&r.ringMem[p.cqOff.cqes])), p.cqEntries)
+
+	if err := r.registerBuffers(); err != nil {
+		return nil, err
+	}
+
+	go r.run()
+	return r, nil
+}
+
+// registerBuffers registers the ring of buffers used by receives (Linux 5.19+).
+func (r *uring) registerBuffers() error {
+	var err error
+	ringSize := uringBufCount * int(unsafe.Sizeof(uringBuf{}))
+	if r.bufRingMem, err = syscall.Mmap(-1, 0, ringSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS); err != nil {
+		return newError("mmap", err)
+	}
+	if r.bufMem, err = syscall.Mmap(-1, 0, uringBufCount*uringBufSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS); err != nil {
+		return newError("mmap", err)
+	}
+	r.bufs = unsafe.Slice((*uringBuf)(unsafe.Pointer(&r.bufRingMem[0])), uringBufCount)
+	r.bufTail = &r.bufs[0].resv
+
+	reg := uringBufReg{
+		ringAddr:    uint64(uintptr(unsafe.Pointer(&r.bufRingMem[0]))),
+		ringEntries: uringBufCount,
+		bgid:        uringBufGroup,
+	}
+	_, _, errno := syscall.Syscall6(sysIOURingRegister, uintptr(r.fd), uringRegisterPbufRing, uintptr(unsafe.Pointer(&reg)), 1, 0, 0)
+	if errno != 0 {
+		return newError("io_uring_register", errno)
+	}
+
+	for bid := 0; bid < uringBufCount; bid++ {
+		r.addBuffer(uint16(bid), uint16(bid))
+	}
+	r.advanceBuffers(uringBufCount)
+	return nil
+}
+
+// addBuffer puts the buffer at the ring offset, the tail is advanced by the caller.
+func (r *uring) addBuffer(bid, offset uint16) {
+	b := &r.bufs[(*r.bufTail+offset)&(uringBufCount-1)]
+	b.addr = uint64(uintptr(unsafe.Pointer(&r.bufMem[int(bid)*uringBufSize])))
+	b.len = uringBufSize
+	b.bid = bid
+}
+
+// buffer returns the provided buffer filled by a receive.
+func (r *uring) buffer(bid uint32, n int) []byte {
+	off := int(bid) * uringBufSize
+	return r.bufMem[off : off+n]
+}
+
+// recycle returns the provided buffer to the kernel.
+func (r *uring) recycle(bid uint32) {
+	r.mu.Lock()
+	r.addBuffer(uint16(bid), 0)
+	r.advanceBuffers(1)
+	r.mu.Unlock()
+}
+
+// advanceBuffers publishes n added buffers to the kernel.
+// The 16-bit tail shares a 32-bit word with the bid of the first entry,
+// the word is updated atomically to order it after the entries.
+func (r *uring) advanceBuffers(n uint32) {
+	word := (*uint32)(unsafe.Pointer(&r.bufRingMem[12]))
+	if nativeLittleEndian {
+		atomic.AddUint32(word, n<<16)
+	} else {
+		atomic.AddUint32(word, n)
+	}
+}
+
+var nativeLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// submit submits an operation, fn is called by the reactor with the result.
+func (r *uring) submit(fill func(sqe *uringSQE), fn func(res int32, flags uint32)) uint64 {
+	r.mu.Lock()
+	id := r.nextID
+	r.nextID++
+	r.ops[id] = fn
+	r.push(id, fill)
+	r.mu.Unlock()
+
+	r.flush()
+	return id
+}
+
+// cancel cancels the operation, its fn is still called.
+func (r *uring) cancel(id uint64) {
+	r.mu.Lock()
+	r.push(uringCancelID, func(sqe *uringSQE) {
+		sqe.opcode = uringOpAsyncCancel
+		sqe.addr = id
+	})
+	r.mu.Unlock()
+
+	r.flush()
+}
+
+// cancelFD cancels all operations on the fd.
+func (r *uring) cancelFD(fd int) {
+	r.mu.Lock()
+	r.push(uringCancelID, func(sqe *uringSQE) {
+		sqe.opcode = uringOpAsyncCancel
+		sqe.fd = int32(fd)
+		sqe.opFlags = uringCancelAll | uringCancelFD
+	})
+	r.mu.Unlock()
+
+	r.flush()
+}
+
+// push puts an entry to the submission queue, r.mu must be held.
+func (r *uring) push(id uint64, fill func(sqe *uringSQE)) {
+	for {
+		tail := atomic.LoadUint32(r.sqTail)
+		if tail-atomic.LoadUint32(r.sqHead) < uint32(len(r.sqes)) {
+			idx := tail & r.sqMask
+			r.sqes[idx] = uringSQE{userData: id}
+			fill(&r.sqes[idx])
+			r.sqArray[idx] = idx
+			atomic.StoreUint32(r.sqTail, tail+1)
+			r.queued++
+			return
+		}
+
+		// queue is full
+		r.mu.Unlock()
+		r.flush()
+		runtime.Gosched()
+		r.mu.Lock()
+	}
+}
+
+// flush submits the queued entries in batches.
+// If another goroutine is already submitting, it returns immediately
+// and the entries are submitted by that goroutine.
+func (r *uring) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.flushing {
+		return
+	}
+	r.flushing = true
+	for r.queued > 0 {
+		n := r.queued
+		r.queued = 0
+		r.mu.Unlock()
+		n = r.enter(n)
+		r.mu.Lock()
+		if n > 0 {
+			// submission failed, the entries are submitted by the next flush
+			r.queued += n
+			break
+		}
+	}
+	r.flushing = false
+}
+
+// enter submits n entries and returns the number of entries that are not submitted.
+func (r *uring) enter(n uint32) uint32 {
+	for n > 0 {
+		submitted, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), uintptr(n), 0, 0, 0, 0)
+		switch errno {
+		case 0:
+			n -= uint32(submitted)
+		case syscall.EINTR:
+		case syscall.EAGAIN, syscall.EBUSY:
+			// completion queue is full, let the reactor reap it
+			time.Sleep(50 * time.Microsecond)
+		default:
+			return n
+		}
+	}
+	return 0
+}
+
+func (r *uring) acquire() {
+	r.mu.Lock()
+	r.refs++
+	r.mu.Unlock()
+}
+
+// release drops the reference, the reactor stops when there are no references and operations.
+func (r *uring) release() {
+	r.mu.Lock()
+	r.refs--
+	r.push(uringWakeID, func(sqe *uringSQE) {
+		sqe.opcode = uringOpNop
+	})
+	r.mu.Unlock()
+
+	r.flush()
+}
+
+// run dispatches completions.
+func (r *uring) run() {
+	for {
+		r.mu.Lock()
+		if r.refs == 0 && len(r.ops) == 0 {
+			r.mu.Unlock()
+			r.free()
+			return
+		}
+		r.mu.Unlock()
+
+		_, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), 0, 1, uringEnterGetEvents, 0, 0)
+		if errno != 0 && errno != syscall.EINTR {
+			time.Sleep(time.Millisecond)
+		}
+		r.reap()
+	}
+}
+
+func (r *uring) reap() {
+	head := atomic.LoadUint32(r.cqHead)
+	tail := atomic.LoadUint32(r.cqTail)
+	for ; head != tail; head++ {
+		cqe := r.cqes[head&r.cqMask]
+		atomic.StoreUint32(r.cqHead, head+1)
+		r.complete(cqe)
+	}
+}
+
+func (r *uring) complete(cqe uringCQE) {
+	if cqe.userData == uringWakeID || cqe.userData == uringCancelID {
+		return
+	}
+
+	r.mu.Lock()
+	fn := r.ops[cqe.userData]
+	if cqe.flags&uringCQEFMore == 0 {
+		delete(r.ops, cqe.userData)
+	}
+	r.mu.Unlock()
+
+	if fn != nil {
+		fn(cqe.res, cqe.flags)
+	}
+}
+
+func (r *uring) free() {
+	for _, mem := range [][]byte{r.ringMem, r.sqeMem, r.bufRingMem, r.bufMem} {
+		if mem != nil {
+			syscall.Munmap(mem)
+		}
+	}
+	syscall.Close(r.fd)
+}
+
+// uringListener accepts connections with a multishot accept.
+type uringListener struct {
+	fd    int
+	ring  *uring
+	addr  net.Addr
+	cfg   *TCPListenerConfig
+	stats *Stats
+
+	mu       sync.Mutex
+	queue    []uringAccepted
+	armed    bool
+	acceptID uint64
+	readyCh  chan struct{}
+	disarmCh chan struct{}
+
+	closeOnce sync.Once
+	doneCh    chan struct{}
+}
+
+type uringAccepted struct {
+	fd  int
+	err error
+}
+
+// newURingListener returns an io_uring listener for the listening socket,
+// ErrNotSupported or a syscall error is returned if the kernel lacks io_uring
+// or it's restricted by sysctl or seccomp.
+func newURingListener(fd int, cfg *TCPListenerConfig, stats *Stats) (net.Listener, error) {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return nil, newError("getsockname", err)
+	}
+	ring, err := newURing()
+	if err != nil {
+		return nil, err
+	}
+	return &uringListener{
+		fd:       fd,
+		ring:     ring,
+		addr:     sockaddrToTCPAddr(sa),
+		cfg:      cfg,
+		stats:    stats,
+		readyCh:  make(chan struct{}, 1),
+		disarmCh: make(chan struct{}, 1),
+		doneCh:   make(chan struct{}),
+	}, nil
+}
+
+// Accept waits for and returns the next connection.
+func (ln *uringListener) Accept() (net.Conn, error) {
+	for {
+		ln.mu.Lock()
+		if !ln.armed {
+			ln.arm()
+		}
+		var acc uringAccepted
+		ok := len(ln.queue) > 0
+		if ok {
+			acc = ln.queue[0]
+			ln.queue = ln.queue[1:]
+		}
+		ln.mu.Unlock()
+
+		if !ok {
+			select {
+			case <-ln.readyCh:
+				continue
+			case <-ln.doneCh:
+				return nil, ln.opError(net.ErrClosed)
+			}
+		}
+		if acc.err != nil {
+			return nil, ln.opError(acc.err)
+		}
+
+		if ln.cfg.OnAccept != nil {
+			if err := ln.cfg.OnAccept(uintptr(acc.fd)); err != nil {
+				syscall.Close(acc.fd)
+				ln.stats.acceptsInc()
+				ln.stats.acceptErrorsInc()
+				continue
+			}
+		}
+		return ln.newConn(acc.fd), nil
+	}
+}
+
+// arm submits the multishot accept, ln.mu must be held.
+func (ln *uringListener) arm() {
+	select {
+	case <-ln.doneCh:
+		return
+	default:
+	}
+
+	select {
+	case <-ln.disarmCh:
+	default:
+	}
+	ln.armed = true
+	ln.acceptID = ln.ring.submit(func(sqe *uringSQE) {
+		sqe.opcode = uringOpAccept
+		sqe.fd = int32(ln.fd)
+		sqe.ioprio = uringAcceptMultishot
+		sqe.opFlags = syscall.SOCK_NONBLOCK | syscall.SOCK_CLOEXEC
+	}, ln.accepted)
+}
+
+func (ln *uringListener) accepted(res int32, flags uint32) {
+	ln.mu.Lock()
+	switch {
+	case res >= 0:
+		ln.queue = append(ln.queue, uringAccepted{fd: int(res)})
+	case res != -int32(syscall.ECANCELED):
+		ln.queue = append(ln.queue, uringAccepted{err: newError("accept", syscall.Errno(-res))})
+	}
+	if flags&uringCQEFMore == 0 {
+		ln.armed = false // rearmed by the next Accept
+		select {
+		case ln.disarmCh <- struct{}{}:
+		default:
+		}
+	}
+	ln.mu.Unlock()
+
+	select {
+	case ln.readyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (ln *uringListener) newConn(fd int) *uringConn {
+	ln.ring.acquire()
+	c := &uringConn{
+		fd:            fd,
+		ring:          ln.ring,
+		laddr:         ln.addr,
+		stats:         ln.stats,
+		shard:         pickShard(),
+		readTimeout:   ln.cfg.ReadTimeout,
+		writeTimeout:  ln.cfg.WriteTimeout,
+		readDeadline:  makeMemDeadline(),
+		writeDeadline: makeMemDeadline(),
+	}
+	if sa, err := syscall.Getpeername(fd); err == nil {
+		c.raddr = sockaddrToTCPAddr(sa)
+	} else {
+		c.raddr = &net.TCPAddr{}
+	}
+	return c
+}
+
+// Close stops accepting, connections that are not accepted yet are closed.
+func (ln *uringListener) Close() error {
+	var err error
+	ln.closeOnce.Do(func() {
+		close(ln.doneCh)
+
+		ln.mu.Lock()
+		armed := ln.armed
+		if armed {
+			ln.ring.cancel(ln.acceptID)
+		}
+		ln.mu.Unlock()
+		if armed {
+			<-ln.disarmCh
+		}
+
+		err = newError("close", syscall.Close(ln.fd))
+		ln.mu.Lock()
+		for _, acc := range ln.queue {
+			if acc.err == nil {
+				syscall.Close(acc.fd)
+			}
+		}
+		ln.queue = nil
+		ln.mu.Unlock()
+		ln.ring.release()
+	})
+	return err
+}
+
+// control invokes fn on the listening socket fd.
+func (ln *uringListener) control(fn func(fd int) error) error {
+	select {
+	case <-ln.doneCh:
+		return ln.opError(net.ErrClosed)
+	default:
+	}
+	return fn(ln.fd)
+}
+
+// Addr returns the listener address.
+func (ln *uringListener) Addr() net.Addr {
+	return ln.addr
+}
+
+func (ln *uringListener) opError(err error) error {
+	return &net.OpError{Op: "accept", Net: "tcp", Addr: ln.addr, Err: err}
+}
+
+// uringConn is a connection served by io_uring, receives use the provided buffers.
+type uringConn struct {
+	fd    int
+	ring  *uring
+	laddr net.Addr
+	raddr net.Addr
+	stats *Stats
+	shard uint32 // of stats io counters
+
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	readDeadline  memDeadline
+	writeDeadline memDeadline
+
+	readMu  sync.Mutex
+	rbuf    []byte // unread part of a provided buffer
+	rbid    uint32
+	writeMu sync.Mutex
+
+	opMu      sync.RWMutex // held for reading by in-flight operations
+	closed    int32
+	closeOnce sync.Once
+}
+
+var _ CtxConn = &uringConn{}
+
+// Read reads data from the connection.
+func (c *uringConn) Read(p []byte) (int, error) {
+	return c.ReadContext(context.Background(), p)
+}
+
+// ReadContext does same as Read method but with a context.
+func (c *uringConn) ReadContext(ctx context.Context, p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if len(c.rbuf) > 0 {
+		n := copy(p, c.rbuf)
+		c.rbuf = c.rbuf[n:]
+		if len(c.rbuf) == 0 {
+			c.rbuf = nil
+			c.ring.recycle(c.rbid)
+		}
+		return n, nil
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if c.readTimeout > 0 {
+		c.readDeadline.set(time.Now().Add(c.readTimeout))
+	}
+	res, flags, err := c.do(ctx, c.readDeadline.wait(), func(sqe *uringSQE) {
+		sqe.opcode = uringOpRecv
+		sqe.fd = int32(c.fd)
+		sqe.len = uringBufSize
+		sqe.flags = uringSQEBufferSelect
+		sqe.bufIndex = uringBufGroup
+	}, nil)
+	if err == nil && res == -int32(syscall.ENOBUFS) {
+		// all the provided buffers are in use, receive directly
+		res, flags, err = c.do(ctx, c.readDeadline.wait(), func(sqe *uringSQE) {
+			sqe.opcode = uringOpRecv
+			sqe.fd = int32(c.fd)
+			sqe.addr = uint64(uintptr(unsafe.Pointer(&p[0])))
+			sqe.len = uint32(len(p))
+		}, p)
+	}
+
+	var n int
+	switch {
+	case err != nil:
+	case atomic.LoadInt32(&c.closed) != 0:
+		err = net.ErrClosed
+	case res < 0:
+		err = newError("recv", syscall.Errno(-res))
+	case res == 0:
+		c.stats.readDone(c.shard, 0, nil)
+		return 0, io.EOF
+	case flags&uringCQEFBuffer != 0:
+		bid := flags >> uringCQEBufferShift
+		data := c.ring.buffer(bid, int(res))
+		n = copy(p, data)
+		if n < len(data) {
+			c.rbuf, c.rbid = data[n:], bid
+		}
+		c.stats.readDone(c.shard, int64(res), nil)
+		if c.rbuf == nil {
+			c.ring.recycle(bid)
+		}
+		return n, nil
+	default:
+		n = int(res)
+	}
+	if flags&uringCQEFBuffer != 0 && res <= 0 {
+		c.ring.recycle(flags >> uringCQEBufferShift)
+	}
+
+	if err != nil {
+		err = c.opError("read", err)
+	}
+	c.stats.readDone(c.shard, int64(n), err)
+	return n, err
+}
+
+// Write writes data to the connection.
+func (c *uringConn) Write(p []byte) (int, error) {
+	return c.WriteContext(context.Background(), p)
+}
+
+// WriteContext does same as Write method but with a context.
+func (c *uringConn) WriteContext(ctx context.Context, p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.writeTimeout > 0 {
+		c.writeDeadline.set(time.Now().Add(c.writeTimeout))
+	}
+
+	var total int
+	var err error
+	for total < len(p) {
+		chunk := p[total:]
+		var res int32
+		res, _, err = c.do(ctx, c.writeDeadline.wait(), func(sqe *uringSQE) {
+			sqe.opcode = uringOpSend
+			sqe.fd = int32(c.fd)
+			sqe.addr = uint64(uintptr(unsafe.Pointer(&chunk[0])))
+			sqe.len = uint32(len(chunk))
+			sqe.opFlags = syscall.MSG_NOSIGNAL
+		}, chunk)
+		if err == nil && res < 0 {
+			err = newError("send", syscall.Errno(-res))
+		}
+		if err != nil {
+			break
+		}
+		total += int(res)
+	}
+
+	if err != nil {
+		if atomic.LoadInt32(&c.closed) != 0 {
+			err = net.ErrClosed
+		}
+		err = c.opError("write", err)
+	}
+	c.stats.writeDone(c.shard, int64(total), err)
+	return total, err
+}
+
+// do submits the operation and waits for its result.
+// The operation is canceled when ctx is done or the deadline is exceeded,
+// if it completes anyway its result is returned without an error.
+func (c *uringConn) do(ctx context.Context, deadlineCh <-chan struct{}, fill func(sqe *uringSQE), keep []byte) (int32, uint32, error) {
+	c.opMu.RLock()
+	defer c.opMu.RUnlock()
+
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return 0, 0, net.ErrClosed
+	}
+	if isClosedChan(deadlineCh) {
+		return 0, 0, os.ErrDeadlineExceeded
+	}
+
+	type result struct {
+		res   int32
+		flags uint32
+	}
+	resCh := make(chan result, 1)
+	id := c.ring.submit(fill, func(res int32, flags uint32) {
+		resCh <- result{res: res, flags: flags}
+	})
+
+	var err error
+	var res result
+	select {
+	case res = <-resCh:
+		runtime.KeepAlive(keep)
+		return res.res, res.flags, nil
+	case <-deadlineCh:
+		err = os.ErrDeadlineExceeded
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.ring.cancel(id)
+	res = <-resCh
+	runtime.KeepAlive(keep)
+	if res.res >= 0 {
+		err = nil
+	}
+	return res.res, res.flags, err
+}
+
+// Close closes the connection.
+func (c *uringConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		atomic.StoreInt32(&c.closed, 1)
+		// shutdown completes operations submitted after the cancel
+		syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+		c.ring.cancelFD(c.fd)
+
+		c.opMu.Lock() // wait for in-flight operations
+		err = newError("close", syscall.Close(c.fd))
+		c.opMu.Unlock()
+
+		c.readMu.Lock()
+		if c.rbuf != nil {
+			c.rbuf = nil
+			c.ring.recycle(c.rbid)
+		}
+		c.readMu.Unlock()
+		c.ring.release()
+
+		c.stats.connsInc()
+		if err != nil {
+			c.stats.closeErrorsInc()
+			err = c.opError("close", err)
+		} else {
+			c.stats.finClosesInc()
+		}
+	})
+	return err
+}
+
+// CloseRead shuts down the reading side of the connection.
+func (c *uringConn) CloseRead() error {
+	return c.shutdown(syscall.SHUT_RD)
+}
+
+// CloseWrite shuts down the writing side of the connection, peer receives FIN.
+func (c *uringConn) CloseWrite() error {
+	return c.shutdown(syscall.SHUT_WR)
+}
+
+func (c *uringConn) shutdown(how int) error {
+	c.opMu.RLock()
+	defer c.opMu.RUnlock()
+
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return c.opError("shutdown", net.ErrClosed)
+	}
+	err := newError("shutdown", syscall.Shutdown(c.fd, how))
+	if how == syscall.SHUT_RD {
+		c.stats.closeReadsInc()
+	} else {
+		c.stats.closeWritesInc()
+	}
+	if err != nil {
+		c.stats.closeErrorsInc()
+		return c.opError("shutdown", err)
+	}
+	return nil
+}
+
+func (c *uringConn) LocalAddr() net.Addr  { return c.laddr }
+func (c *uringConn) RemoteAddr() net.Addr { return c.raddr }
+
+// SetDeadline sets the read and write deadlines.
+func (c *uringConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the read deadline.
+func (c *uringConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline.
+func (c *uringConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *uringConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "tcp", Source: c.laddr, Addr: c.raddr, Err: err}
+}
@@ -0,0 +1,170 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPListener_IOUring(t *testing.T) {
+	ln, err := NewTCPListener(context.Background(), "tcp4", "127.0.0.1:0", TCPListenerConfig{IOUring: true})
+	failIfErr(t, err, "cannot create listener: %s", err)
+	defer ln.Close()
+
+	if _, ok := ln.Listener.(*uringListener); !ok {
+		t.Skip("io_uring is not available")
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(conn, conn)
+		conn.Close()
+	}()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	failIfErr(t, err, "cannot dial: %s", err)
+
+	msg := bytes.Repeat([]byte("netx"), 4096)
+	go func() {
+		client.Write(msg)
+		client.(*net.TCPConn).CloseWrite()
+	}()
+
+	got, err := io.ReadAll(client)
+	failIfErr(t, err, "cannot read: %s", err)
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("want %d echoed bytes, got %d", len(msg), len(got))
+	}
+	client.Close()
+
+	stats := ln.Stats()
+	if stats.ReadBytes() != uint64(len(msg)) || stats.WrittenBytes() != uint64(len(msg)) {
+		t.Fatalf("want %d read and written bytes, got %d and %d", len(msg), stats.ReadBytes(), stats.WrittenBytes())
+	}
+}
+
+func BenchmarkTCPListener_Echo(b *testing.B) {
+	b.Run("poller", func(b *testing.B) {
+		benchmarkEcho(b, TCPListenerConfig{})
+	})
+	b.Run("io_uring", func(b *testing.B) {
+		benchmarkEcho(b, TCPListenerConfig{IOUring: true})
+	})
+}
+
+func benchmarkEcho(b *testing.B, cfg TCPListenerConfig) {
+	ln, err := NewTCPListener(context.Background(), "tcp4", "127.0.0.1:0", cfg)
+	failIfErr(b, err, "cannot create listener: %s", err)
+	defer ln.Close()
+
+	if _, ok := ln.Listener.(*uringListener); cfg.IOUring && !ok {
+		b.Skip("io_uring is not available")
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	failIfErr(b, err, "cannot dial: %s", err)
+	defer client.Close()
+
+	buf := make([]byte, 4096)
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Write(buf); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(client, buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTCPListener_IOUringDeadline(t *testing.T) {
+	ln, err := NewTCPListener(context.Background(), "tcp4", "127.0.0.1:0", TCPListenerConfig{IOUring: true})
+	failIfErr(t, err, "cannot create listener: %s", err)
+	defer ln.Close()
+
+	if _, ok := ln.Listener.(*uringListener); !ok {
+		t.Skip("io_uring is not available")
+	}
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer client.Close()
+
+	conn, err := ln.Accept()
+	failIfErr(t, err, "cannot accept: %s", err)
+
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 16))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("want timeout, got %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	conn.SetReadDeadline(time.Time{})
+	go func() {
+		_, err := conn.Read(make([]byte, 16))
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+	if err := <-errCh; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("want closed error, got %v", err)
+	}
+}
+
+func TestTCPListener_IOUringMux(t *testing.T) {
+	ln, err := NewTCPListener(context.Background(), "tcp4", "127.0.0.1:0", TCPListenerConfig{IOUring: true})
+	failIfErr(t, err, "cannot create listener: %s", err)
+	defer ln.Close()
+
+	if _, ok := ln.Listener.(*uringListener); !ok {
+		t.Skip("io_uring is not available")
+	}
+
+	m := NewMux(ln, MuxConfig{})
+	if err := m.Serve(); err != errNotConn {
+		t.Fatalf("want errNotConn, got %v", err)
+	}
+
+	_, err = NewTLSListener(context.Background(), "tcp4", "127.0.0.1:0", TCPListenerConfig{IOUring: true}, &tls.Config{Certificates: []tls.Certificate{{}}})
+	if err != errNotConn {
+		t.Fatalf("want errNotConn, got %v", err)
+	}
+}
+
+func TestReusePortGroup_IOUringSteerByCPU(t *testing.T) {
+	cfg := ReusePortGroupConfig{
+		Listener:   TCPListenerConfig{IOUring: true},
+		Size:       2,
+		SteerByCPU: true,
+	}
+	g, err := NewReusePortGroup(context.Background(), "tcp4", "127.0.0.1:0", cfg)
+	if errors.Is(err, ErrNotSupported) {
+		t.Skip(err)
+	}
+	failIfErr(t, err, "cannot create group: %s", err)
+	g.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package netx
+
+import "net"
+
+func newURingListener(fd int, cfg *TCPListenerConfig, stats *Stats) (net.Listener, error) {
+	return nil, ErrNotSupported
+}
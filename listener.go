@@ -10,6 +10,9 @@ import (
 	"time"
 )
 
+// errNotConn is returned when a listener with io_uring is used where *Conn is required.
+var errNotConn = errors.New("netx: io_uring connections are not supported")
+
 // TCPListenerConfig is a config TCPListener.
 type TCPListenerConfig struct {
 	// ReusePort enables SO_REUSEPORT.
@@ -28,6 +31,51 @@ type TCPListenerConfig struct {
 	// may queue before passing them to Accept.
 	// Default is system-level backlog value is used.
 	Backlog int
+
+	// OnAccept is called with the fd of every accepted connection
+	// before it's wrapped into Conn, use it to apply per-connection socket options.
+	// If it returns an error the connection is closed and counted as an accept error.
+	OnAccept func(fd uintptr) error
+
+	// ReadLimit is the read throughput in bytes per second shared
+	// by all accepted connections, 0 is unlimited.
+	// See TCPListener.ReadLimiter to change it at runtime.
+	ReadLimit int
+
+	// WriteLimit is the write throughput in bytes per second shared
+	// by all accepted connections, 0 is unlimited.
+	// See TCPListener.WriteLimiter to change it at runtime.
+	WriteLimit int
+
+	// ReadTimeout is set as a read deadline before every Read of accepted connections.
+	ReadTimeout time.Duration
+
+	// WriteTimeout is set as a write deadline before every Write of accepted connections.
+	WriteTimeout time.Duration
+
+	// IdleTimeout closes accepted connections without reads and writes for this duration.
+	IdleTimeout time.Duration
+
+	// MinReadRate aborts accepted connections that send data slower than the rate.
+	// It overrides read deadlines set by the user.
+	MinReadRate MinRate
+
+	// MinWriteRate aborts accepted connections that receive data slower than the rate.
+	// It overrides write deadlines set by the user.
+	MinWriteRate MinRate
+
+	// SampleTCPInfo samples TCP_INFO of every accepted connection
+	// on close into the listener Stats, see Conn.TCPInfo for the platforms.
+	SampleTCPInfo bool
+
+	// IOUring enables the experimental io_uring backend on Linux 5.19+.
+	// It falls back to the runtime poller when io_uring is not available,
+	// disabled by sysctl or blocked by seccomp.
+	//
+	// Accepted connections are not *Conn, rate limits, minimum rates,
+	// idle timeout and TCP_INFO sampling are not supported.
+	// Mux and TLSListener don't support it.
+	IOUring bool
 }
 
 // TCPListener listens for the addr passed to NewTCPListener.
@@ -35,13 +83,20 @@ type TCPListenerConfig struct {
 // It also gathers various stats for the accepted connections.
 type TCPListener struct {
 	net.Listener
-	cfg   TCPListenerConfig
-	stats *Stats
+	cfg          TCPListenerConfig
+	stats        *Stats
+	readLimiter  *RateLimiter
+	writeLimiter *RateLimiter
+	idle         *idleWheel
 }
 
 // NewTCPListener returns new TCP listener for the given addr.
 func NewTCPListener(ctx context.Context, network, addr string, cfg TCPListenerConfig) (*TCPListener, error) {
-	ln, err := cfg.newListener(network, addr)
+	return newTCPListener(ctx, network, addr, cfg, &Stats{})
+}
+
+func newTCPListener(ctx context.Context, network, addr string, cfg TCPListenerConfig, stats *Stats) (*TCPListener, error) {
+	ln, err := cfg.newListener(network, addr, stats)
 	if err != nil {
 		return nil, err
 	}
@@ -52,15 +107,33 @@ func NewTCPListener(ctx context.Context, network, addr string, cfg TCPListenerCo
 	}()
 
 	tln := &TCPListener{
-		Listener: ln,
-		cfg:      cfg,
-		stats:    &Stats{},
+		Listener:     ln,
+		cfg:          cfg,
+		stats:        stats,
+		readLimiter:  NewRateLimiter(cfg.ReadLimit, 0),
+		writeLimiter: NewRateLimiter(cfg.WriteLimit, 0),
+	}
+	if cfg.IdleTimeout > 0 {
+		tln.idle = newIdleWheel(cfg.IdleTimeout)
 	}
 	return tln, err
 }
 
 // Accept accepts connections from the addr passed to NewTCPListener.
+//
+// Accepted sockets are already non-blocking and close-on-exec,
+// the runtime uses accept4(2) with SOCK_NONBLOCK|SOCK_CLOEXEC where available.
 func (ln *TCPListener) Accept() (net.Conn, error) {
+	conn, err := ln.accept()
+	if c, ok := conn.(*Conn); ok && c.idle != nil {
+		c.idle.add(c)
+	}
+	return conn, err
+}
+
+// accept is Accept without adding the conn to the idle wheel,
+// so Mux can set the conn stats before the wheel sees it.
+func (ln *TCPListener) accept() (net.Conn, error) {
 	for {
 		conn, err := ln.Listener.Accept()
 		ln.stats.acceptsInc()
@@ -76,29 +149,109 @@ func (ln *TCPListener) Accept() (net.Conn, error) {
 
 		tcpconn, ok := conn.(*net.TCPConn)
 		if !ok {
-			panic("unreachable")
+			// io_uring connections are set up by the listener
+			ln.stats.activeConnsInc()
+			return conn, nil
 		}
 
-		ln.stats.activeConnsInc()
-		sc := &Conn{
-			TCPConn: *tcpconn,
-			stats:   ln.stats,
+		if ln.cfg.OnAccept != nil {
+			if err := ln.onAccept(tcpconn); err != nil {
+				tcpconn.Close()
+				ln.stats.acceptErrorsInc()
+				continue
+			}
 		}
+
+		ln.stats.activeConnsInc()
+		sc := newConn(tcpconn, ln.stats, ln.readLimiter, ln.writeLimiter)
+		sc.sampleTCPInfo = ln.cfg.SampleTCPInfo
+		sc.readTimeout = ln.cfg.ReadTimeout
+		sc.writeTimeout = ln.cfg.WriteTimeout
+		sc.minRead = newRateFloor(ln.cfg.MinReadRate, true)
+		sc.minWrite = newRateFloor(ln.cfg.MinWriteRate, false)
+		sc.idle = ln.idle
 		return sc, nil
 	}
 }
 
+func (ln *TCPListener) onAccept(conn *net.TCPConn) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var fnErr error
+	err = rc.Control(func(fd uintptr) {
+		fnErr = ln.cfg.OnAccept(fd)
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// acceptsConn reports whether Accept returns *Conn, it doesn't with io_uring.
+func (ln *TCPListener) acceptsConn() bool {
+	_, ok := ln.Listener.(*net.TCPListener)
+	return ok
+}
+
 // Stats of the listener and accepted connections.
 func (ln *TCPListener) Stats() *Stats {
 	return ln.stats
 }
 
-func (cfg *TCPListenerConfig) newListener(network, addr string) (net.Listener, error) {
+// ReadLimiter limits the read throughput shared by all accepted connections.
+func (ln *TCPListener) ReadLimiter() *RateLimiter {
+	return ln.readLimiter
+}
+
+// WriteLimiter limits the write throughput shared by all accepted connections.
+func (ln *TCPListener) WriteLimiter() *RateLimiter {
+	return ln.writeLimiter
+}
+
+// controller is implemented by listeners that are not syscall.Conn, like io_uring one.
+type controller interface {
+	control(fn func(fd int) error) error
+}
+
+// control invokes fn on the listening socket fd.
+func (ln *TCPListener) control(fn func(fd int) error) error {
+	if c, ok := ln.Listener.(controller); ok {
+		return c.control(fn)
+	}
+	sc, ok := ln.Listener.(syscall.Conn)
+	if !ok {
+		return ErrNotSupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var fnErr error
+	err = rc.Control(func(fd uintptr) {
+		fnErr = fn(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+func (cfg *TCPListenerConfig) newListener(network, addr string, stats *Stats) (net.Listener, error) {
 	fd, err := cfg.newSocket(network, addr)
 	if err != nil {
 		return nil, err
 	}
 
+	if cfg.IOUring {
+		if ln, err := newURingListener(fd, cfg, stats); err == nil {
+			return ln, nil
+		}
+	}
+
 	name := fmt.Sprintf("netx.%d.%s.%s", os.Getpid(), network, addr)
 	file := os.NewFile(uintptr(fd), name)
 
@@ -25,6 +25,16 @@ func enableFastOpen(fd, queueLen int) error {
 	return nil
 }
 
+func attachReusePortCPUSteering(fd, groupSize int) error {
+	return ErrNotSupported
+}
+
+// PinToCPU locks the calling goroutine to its OS thread and binds the thread to the cpu.
+// Not supported on this platform.
+func PinToCPU(cpu int) error {
+	return ErrNotSupported
+}
+
 func setBacklog(fd, backlog int) error {
 	return newError("listen", syscall.Listen(fd, backlog))
 }
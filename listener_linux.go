@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
+	"unsafe"
 )
 
 const (
@@ -28,6 +30,52 @@ func enableDeferAccept(fd int) error {
 	return newError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT, 1))
 }
 
+func setLinger(fd, sec int) error {
+	var l syscall.Linger
+	if sec >= 0 {
+		l.Onoff, l.Linger = 1, int32(sec)
+	}
+	return newError("setsockopt", syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, &l))
+}
+
+const (
+	soAttachReusePortCBPF = 0x33
+	bpfMod                = 0x90
+	skfAdOffCPU           = 0xfffff000 + 36 // SKF_AD_OFF + SKF_AD_CPU
+)
+
+// attachReusePortCPUSteering makes the kernel pick the listener of the
+// SO_REUSEPORT group by the CPU that handles the incoming packet.
+func attachReusePortCPUSteering(fd, groupSize int) error {
+	prog := []syscall.SockFilter{
+		{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: skfAdOffCPU},
+		{Code: syscall.BPF_ALU | bpfMod | syscall.BPF_K, K: uint32(groupSize)},
+		{Code: syscall.BPF_RET | syscall.BPF_A},
+	}
+	fprog := syscall.SockFprog{
+		Len:    uint16(len(prog)),
+		Filter: &prog[0],
+	}
+	return setsockopt(fd, syscall.SOL_SOCKET, soAttachReusePortCBPF, unsafe.Pointer(&fprog), unsafe.Sizeof(fprog))
+}
+
+// PinToCPU locks the calling goroutine to its OS thread and binds the thread to the cpu.
+func PinToCPU(cpu int) error {
+	var mask [1024 / 64]uint64
+	if cpu < 0 || cpu >= len(mask)*64 {
+		return fmt.Errorf("cpu %d is out of range", cpu)
+	}
+	mask[cpu/64] |= 1 << (uint(cpu) % 64)
+
+	runtime.LockOSThread()
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		runtime.UnlockOSThread()
+		return newError("sched_setaffinity", errno)
+	}
+	return nil
+}
+
 const fastOpenQueueLen = 16 * 1024
 
 func enableFastOpen(fd int, queueLen int) error {
@@ -2,9 +2,13 @@ package netx
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"runtime"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -62,6 +66,230 @@ func TestTCPListener_Backlog(t *testing.T) {
 	testConfig(t, cfg)
 }
 
+func TestTCPListener_OnAccept(t *testing.T) {
+	ctx := context.Background()
+	cfg := TCPListenerConfig{
+		OnAccept: func(fd uintptr) error {
+			return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1)
+		},
+	}
+	ln, err := NewTCPListener(ctx, "tcp", "127.0.0.1:8083", cfg)
+	failIfErr(t, err, "cannot create listener: %s", err)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", "127.0.0.1:8083")
+	failIfErr(t, err, "cannot dial")
+	defer client.Close()
+
+	conn, err := ln.Accept()
+	failIfErr(t, err, "cannot accept")
+	defer conn.Close()
+
+	var keepAlive int
+	var optErr error
+	err = conn.(*Conn).Control(func(fd uintptr) {
+		keepAlive, optErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+	})
+	failIfErr(t, err, "cannot control: %s", err)
+	failIfErr(t, optErr, "cannot get SO_KEEPALIVE: %s", optErr)
+
+	if keepAlive == 0 {
+		t.Fatal("SO_KEEPALIVE must be set by OnAccept")
+	}
+}
+
+func TestTCPListener_Timeouts(t *testing.T) {
+	ctx := context.Background()
+	cfg := TCPListenerConfig{
+		ReadTimeout: 20 * time.Millisecond,
+		IdleTimeout: 100 * time.Millisecond,
+	}
+	ln, err := NewTCPListener(ctx, "tcp4", "127.0.0.1:0", cfg)
+	failIfErr(t, err, "cannot create listener: %s", err)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	failIfErr(t, err, "cannot dial")
+	defer client.Close()
+
+	conn, err := ln.Accept()
+	failIfErr(t, err, "cannot accept")
+
+	var ne net.Error
+	_, err = conn.Read(make([]byte, 8))
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("want timeout, got %v", err)
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadAll(client)
+	failIfErr(t, err, "want EOF on idle close, got %s", err)
+
+	stats := ln.Stats()
+	if got := stats.ReadTimeouts(); got != 1 {
+		t.Fatalf("want 1 read timeout, got %d", got)
+	}
+	if got := stats.IdleCloses(); got != 1 {
+		t.Fatalf("want 1 idle close, got %d", got)
+	}
+}
+
+func TestTCPListener_MinReadRate(t *testing.T) {
+	ctx := context.Background()
+	cfg := TCPListenerConfig{
+		MinReadRate: MinRate{Bytes: 100, Period: 50 * time.Millisecond},
+	}
+	ln, err := NewTCPListener(ctx, "tcp4", "127.0.0.1:0", cfg)
+	failIfErr(t, err, "cannot create listener: %s", err)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	failIfErr(t, err, "cannot dial")
+	defer client.Close()
+
+	go func() {
+		for i := 0; i < 20; i++ {
+			if _, err := client.Write([]byte("x")); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	conn, err := ln.Accept()
+	failIfErr(t, err, "cannot accept")
+
+	_, err = io.ReadAll(conn)
+	if !errors.Is(err, ErrTooSlow) {
+		t.Fatalf("want too slow, got %v", err)
+	}
+	if got := ln.Stats().SlowCloses(); got != 1 {
+		t.Fatalf("want 1 slow close, got %d", got)
+	}
+}
+
+func TestTCPListener_MinRatePause(t *testing.T) {
+	ctx := context.Background()
+	cfg := TCPListenerConfig{
+		MinReadRate:  MinRate{Bytes: 10, Period: 50 * time.Millisecond},
+		MinWriteRate: MinRate{Bytes: 10, Period: 50 * time.Millisecond},
+	}
+	ln, err := NewTCPListener(ctx, "tcp4", "127.0.0.1:0", cfg)
+	failIfErr(t, err, "cannot create listener: %s", err)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	failIfErr(t, err, "cannot dial")
+	defer client.Close()
+
+	conn, err := ln.Accept()
+	failIfErr(t, err, "cannot accept")
+	defer conn.Close()
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		client.Write([]byte("ping"))
+		io.Copy(io.Discard, client)
+	}()
+
+	// an idle peer is not slow
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	failIfErr(t, err, "cannot read: %s", err)
+
+	// neither is a pause of the application
+	_, err = conn.Write([]byte("pong"))
+	failIfErr(t, err, "cannot write: %s", err)
+	time.Sleep(200 * time.Millisecond)
+	_, err = conn.Write([]byte("pong"))
+	failIfErr(t, err, "cannot write after pause: %s", err)
+
+	if got := ln.Stats().SlowCloses(); got != 0 {
+		t.Fatalf("want no slow closes, got %d", got)
+	}
+}
+
+func TestReusePortGroup(t *testing.T) {
+	ctx := context.Background()
+	cfg := ReusePortGroupConfig{
+		Size:       4,
+		SteerByCPU: true,
+	}
+	g, err := NewReusePortGroup(ctx, "tcp4", "127.0.0.1:10082", cfg)
+	if errors.Is(err, ErrNotSupported) {
+		t.Skip(err)
+	}
+	failIfErr(t, err, "cannot create group: %s", err)
+
+	doneCh := make(chan struct{}, cfg.Size)
+	for _, ln := range g.Listeners() {
+		ln := ln
+		go func() {
+			serveEcho(t, ln)
+			doneCh <- struct{}{}
+		}()
+	}
+
+	const requestsCount = 100
+	for i := 0; i < requestsCount; i++ {
+		c, err := net.Dial("tcp4", "127.0.0.1:10082")
+		failIfErr(t, err, "%d. cannot dial: %s", i, err)
+
+		_, err = c.Write([]byte("ping"))
+		failIfErr(t, err, "%d. cannot write: %s", i, err)
+		err = c.(*net.TCPConn).CloseWrite()
+		failIfErr(t, err, "%d. cannot close write: %s", i, err)
+
+		resp, err := io.ReadAll(c)
+		failIfErr(t, err, "%d. cannot read: %s", i, err)
+		if string(resp) != "ping" {
+			t.Fatalf("%d. unexpected response %q", i, resp)
+		}
+		c.Close()
+	}
+
+	err = g.Close()
+	failIfErr(t, err, "cannot close group: %s", err)
+	for i := 0; i < cfg.Size; i++ {
+		<-doneCh
+	}
+
+	if got := g.Stats().Accepts(); got < requestsCount {
+		t.Fatalf("want at least %d accepts, got %d", requestsCount, got)
+	}
+}
+
+func TestTCPListener_SampleTCPInfo(t *testing.T) {
+	ctx := context.Background()
+	ln, err := NewTCPListener(ctx, "tcp4", "127.0.0.1:0", TCPListenerConfig{SampleTCPInfo: true})
+	failIfErr(t, err, "cannot create listener: %s", err)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	failIfErr(t, err, "cannot dial")
+	defer client.Close()
+
+	conn, err := ln.Accept()
+	failIfErr(t, err, "cannot accept")
+
+	info, err := conn.(*Conn).TCPInfo()
+	if err == ErrNotSupported {
+		t.Skip(err)
+	}
+	failIfErr(t, err, "cannot get tcp info: %s", err)
+
+	if info.State != TCPStateEstablished {
+		t.Fatalf("unexpected state %s", info.State)
+	}
+
+	err = conn.Close()
+	failIfErr(t, err, "cannot close")
+
+	if got := ln.Stats().TCPInfoSamples(); got != 1 {
+		t.Fatalf("want 1 sample, got %d", got)
+	}
+}
+
 func testConfig(t *testing.T, cfg TCPListenerConfig) {
 	testTCPListener(t, cfg, "tcp4", "localhost:10081")
 	// TODO(oleg): fix IPv6
@@ -156,6 +384,41 @@ func serveEcho(t *testing.T, ln net.Listener) {
 }
 
 func BenchmarkListener(b *testing.B) {
+	for _, procs := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("procs=%d/atomic", procs), func(b *testing.B) {
+			var readCalls, readBytes atomicCounter
+			benchmarkStats(b, procs, func() func() {
+				return func() {
+					atomic.AddUint64(&readCalls.count, 1)
+					atomic.AddUint64(&readBytes.count, 512)
+				}
+			})
+		})
+		b.Run(fmt.Sprintf("procs=%d/sharded", procs), func(b *testing.B) {
+			stats := &Stats{}
+			benchmarkStats(b, procs, func() func() {
+				shard := pickShard() // like a conn
+				return func() {
+					stats.readBytesAdd(shard, 512)
+				}
+			})
+		})
+	}
+}
+
+// benchmarkStats runs the stats update in parallel with GOMAXPROCS set to procs,
+// newUpdate returns the update of a goroutine.
+func benchmarkStats(b *testing.B, procs int, newUpdate func() func()) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		update := newUpdate()
+		for pb.Next() {
+			update()
+		}
+	})
 }
 
 func failIfErr(tb testing.TB, err error, format string, args ...interface{}) {
@@ -0,0 +1,421 @@
+package netx
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemListenerConfig is a config for MemListener.
+type MemListenerConfig struct {
+	// BufferSize is the capacity in bytes of each direction of a connection (default 64KB).
+	// Writes block when the buffer is full, like with a real socket.
+	BufferSize int
+
+	// Backlog is the maximum number of dialed connections
+	// waiting for Accept (default 128).
+	Backlog int
+}
+
+// MemListener is an in-memory listener, connections are created with Dial.
+//
+// It gathers the same Stats as TCPListener, use it to test code hermetically.
+type MemListener struct {
+	cfg       MemListenerConfig
+	addr      memAddr
+	connCh    chan *MemConn
+	doneCh    chan struct{}
+	closeOnce sync.Once
+	stats     *Stats
+	dialStats *Stats
+}
+
+var memListenerID uint64
+
+// NewMemListener returns new in-memory listener.
+func NewMemListener(cfg MemListenerConfig) *MemListener {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 64 * 1024
+	}
+	if cfg.Backlog <= 0 {
+		cfg.Backlog = 128
+	}
+
+	id := atomic.AddUint64(&memListenerID, 1)
+	return &MemListener{
+		cfg:       cfg,
+		addr:      memAddr("mem:" + strconv.FormatUint(id, 10)),
+		connCh:    make(chan *MemConn, cfg.Backlog),
+		doneCh:    make(chan struct{}),
+		stats:     &Stats{},
+		dialStats: &Stats{},
+	}
+}
+
+// Dial returns the client side of a new connection, server side is returned by Accept.
+func (ln *MemListener) Dial(ctx context.Context) (*MemConn, error) {
+	select {
+	case <-ln.doneCh:
+		return nil, ln.dialError(net.ErrClosed)
+	default:
+	}
+
+	clientToServer := newMemPipe(ln.cfg.BufferSize)
+	serverToClient := newMemPipe(ln.cfg.BufferSize)
+
+	client := newMemConn(serverToClient, clientToServer, ln.addr, ln.dialStats)
+	server := newMemConn(clientToServer, serverToClient, ln.addr, ln.stats)
+
+	select {
+	case ln.connCh <- server:
+		return client, nil
+	case <-ln.doneCh:
+		return nil, ln.dialError(net.ErrClosed)
+	case <-ctx.Done():
+		return nil, ln.dialError(ctx.Err())
+	}
+}
+
+// Accept waits for and returns the next dialed connection.
+func (ln *MemListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.connCh:
+		ln.stats.acceptsInc()
+		ln.stats.activeConnsInc()
+		return conn, nil
+	case <-ln.doneCh:
+		ln.stats.acceptsInc()
+		ln.stats.acceptErrorsInc()
+		return nil, &net.OpError{Op: "accept", Net: "mem", Addr: ln.addr, Err: net.ErrClosed}
+	}
+}
+
+// Close closes the listener, connections waiting for Accept are closed.
+func (ln *MemListener) Close() error {
+	ln.closeOnce.Do(func() {
+		close(ln.doneCh)
+		for {
+			select {
+			case conn := <-ln.connCh:
+				conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// Addr returns the listener address.
+func (ln *MemListener) Addr() net.Addr {
+	return ln.addr
+}
+
+// Stats of the listener and accepted connections.
+func (ln *MemListener) Stats() *Stats {
+	return ln.stats
+}
+
+// DialStats of the dialed connections.
+func (ln *MemListener) DialStats() *Stats {
+	return ln.dialStats
+}
+
+func (ln *MemListener) dialError(err error) error {
+	return &net.OpError{Op: "dial", Net: "mem", Addr: ln.addr, Err: err}
+}
+
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+// MemConn is an in-memory connection created by MemListener.
+//
+// It mirrors Conn: deadlines, half-close, context-aware i/o and stats.
+type MemConn struct {
+	rx    *memPipe
+	tx    *memPipe
+	addr  memAddr
+	stats *Stats
+	shard uint32 // of stats io counters
+
+	readDeadline  memDeadline
+	writeDeadline memDeadline
+
+	closeOnce sync.Once
+	doneCh    chan struct{}
+}
+
+var _ CtxConn = &MemConn{}
+
+func newMemConn(rx, tx *memPipe, addr memAddr, stats *Stats) *MemConn {
+	return &MemConn{
+		rx:            rx,
+		tx:            tx,
+		addr:          addr,
+		stats:         stats,
+		shard:         pickShard(),
+		readDeadline:  makeMemDeadline(),
+		writeDeadline: makeMemDeadline(),
+		doneCh:        make(chan struct{}),
+	}
+}
+
+// Read reads data from the connection.
+func (c *MemConn) Read(b []byte) (int, error) {
+	return c.ReadContext(context.Background(), b)
+}
+
+// ReadContext does same as Read method but with a context.
+func (c *MemConn) ReadContext(ctx context.Context, b []byte) (int, error) {
+	n, err := c.rx.read(ctx, b, c.doneCh, c.readDeadline.wait())
+	if err != nil && err != io.EOF {
+		err = c.opError("read", err)
+	}
+	c.stats.readDone(c.shard, int64(n), err)
+	return n, err
+}
+
+// Write writes data to the connection.
+func (c *MemConn) Write(b []byte) (int, error) {
+	return c.WriteContext(context.Background(), b)
+}
+
+// WriteContext does same as Write method but with a context.
+func (c *MemConn) WriteContext(ctx context.Context, b []byte) (int, error) {
+	n, err := c.tx.write(ctx, b, c.doneCh, c.writeDeadline.wait())
+	if err != nil {
+		err = c.opError("write", err)
+	}
+	c.stats.writeDone(c.shard, int64(n), err)
+	return n, err
+}
+
+// Close closes the connection.
+func (c *MemConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.doneCh)
+		c.tx.closeWrite()
+		c.rx.closeRead()
+		c.stats.connsInc()
+		c.stats.finClosesInc()
+	})
+	return nil
+}
+
+// CloseRead shuts down the reading side of the connection.
+func (c *MemConn) CloseRead() error {
+	c.rx.closeRead()
+	c.stats.closeReadsInc()
+	return nil
+}
+
+// CloseWrite shuts down the writing side of the connection, peer reads io.EOF.
+func (c *MemConn) CloseWrite() error {
+	c.tx.closeWrite()
+	c.stats.closeWritesInc()
+	return nil
+}
+
+func (c *MemConn) LocalAddr() net.Addr  { return c.addr }
+func (c *MemConn) RemoteAddr() net.Addr { return c.addr }
+
+// SetDeadline sets the read and write deadlines.
+func (c *MemConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the read deadline.
+func (c *MemConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline.
+func (c *MemConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *MemConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "mem", Source: c.addr, Addr: c.addr, Err: err}
+}
+
+// memPipe is a bounded one-directional byte stream.
+type memPipe struct {
+	mu        sync.Mutex
+	buf       []byte
+	size      int
+	wclosed   bool
+	rclosed   bool
+	changedCh chan struct{} // closed and replaced on every change
+}
+
+func newMemPipe(size int) *memPipe {
+	return &memPipe{
+		size:      size,
+		changedCh: make(chan struct{}),
+	}
+}
+
+func (p *memPipe) read(ctx context.Context, b []byte, doneCh, deadlineCh <-chan struct{}) (int, error) {
+	for {
+		p.mu.Lock()
+		switch {
+		case p.rclosed:
+			p.mu.Unlock()
+			return 0, net.ErrClosed
+		case len(p.buf) > 0:
+			n := copy(b, p.buf)
+			p.buf = p.buf[n:]
+			p.changed()
+			p.mu.Unlock()
+			return n, nil
+		case p.wclosed:
+			p.mu.Unlock()
+			return 0, io.EOF
+		}
+		changedCh := p.changedCh
+		p.mu.Unlock()
+
+		if err := memWait(ctx, changedCh, doneCh, deadlineCh); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (p *memPipe) write(ctx context.Context, b []byte, doneCh, deadlineCh <-chan struct{}) (int, error) {
+	var total int
+	for {
+		p.mu.Lock()
+		switch {
+		case p.wclosed:
+			p.mu.Unlock()
+			return total, net.ErrClosed
+		case p.rclosed:
+			p.mu.Unlock()
+			return total, io.ErrClosedPipe
+		case len(p.buf) < p.size:
+			n := p.size - len(p.buf)
+			if n > len(b)-total {
+				n = len(b) - total
+			}
+			p.buf = append(p.buf, b[total:total+n]...)
+			total += n
+			p.changed()
+		}
+		if total == len(b) {
+			p.mu.Unlock()
+			return total, nil
+		}
+		changedCh := p.changedCh
+		p.mu.Unlock()
+
+		if err := memWait(ctx, changedCh, doneCh, deadlineCh); err != nil {
+			return total, err
+		}
+	}
+}
+
+func (p *memPipe) closeWrite() {
+	p.mu.Lock()
+	p.wclosed = true
+	p.changed()
+	p.mu.Unlock()
+}
+
+func (p *memPipe) closeRead() {
+	p.mu.Lock()
+	p.rclosed = true
+	p.buf = nil
+	p.changed()
+	p.mu.Unlock()
+}
+
+// changed wakes up the waiters, p.mu must be held.
+func (p *memPipe) changed() {
+	close(p.changedCh)
+	p.changedCh = make(chan struct{})
+}
+
+func memWait(ctx context.Context, changedCh, doneCh, deadlineCh <-chan struct{}) error {
+	select {
+	case <-changedCh:
+		return nil
+	case <-doneCh:
+		return net.ErrClosed
+	case <-deadlineCh:
+		return os.ErrDeadlineExceeded
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// memDeadline is a deadline that closes a channel when it's exceeded.
+type memDeadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makeMemDeadline() memDeadline {
+	return memDeadline{cancel: make(chan struct{})}
+}
+
+// set sets the deadline, zero t disables it.
+func (d *memDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // wait for the timer callback to close cancel
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel that is closed when the deadline is exceeded.
+func (d *memDeadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
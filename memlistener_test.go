@@ -0,0 +1,93 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemListener(t *testing.T) {
+	ln := NewMemListener(MemListenerConfig{})
+	defer ln.Close()
+
+	go serveEcho(t, ln)
+
+	for i := 0; i < 10; i++ {
+		client, err := ln.Dial(context.Background())
+		failIfErr(t, err, "cannot dial: %s", err)
+
+		_, err = client.Write([]byte("hello"))
+		failIfErr(t, err, "cannot write: %s", err)
+		err = client.CloseWrite()
+		failIfErr(t, err, "cannot close write: %s", err)
+
+		got, err := io.ReadAll(client)
+		failIfErr(t, err, "cannot read: %s", err)
+		if string(got) != "hello" {
+			t.Fatalf("want %q, got %q", "hello", got)
+		}
+		client.Close()
+	}
+
+	if got := ln.Stats().ReadBytes(); got != 50 {
+		t.Fatalf("want 50 read bytes, got %d", got)
+	}
+	if got := ln.DialStats().WrittenBytes(); got != 50 {
+		t.Fatalf("want 50 written bytes, got %d", got)
+	}
+}
+
+func TestMemConn_Backpressure(t *testing.T) {
+	ln := NewMemListener(MemListenerConfig{BufferSize: 4})
+	defer ln.Close()
+
+	client, err := ln.Dial(context.Background())
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer client.Close()
+
+	client.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	n, err := client.Write([]byte("hello world"))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+	if n != 4 {
+		t.Fatalf("want 4 written bytes, got %d", n)
+	}
+	if got := ln.DialStats().WriteTimeouts(); got != 1 {
+		t.Fatalf("want 1 write timeout, got %d", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	conn, err := ln.Accept()
+	failIfErr(t, err, "cannot accept: %s", err)
+	defer conn.Close()
+
+	buf := make([]byte, 8)
+	n, err = conn.(*MemConn).ReadContext(ctx, buf)
+	failIfErr(t, err, "cannot read: %s", err)
+	if string(buf[:n]) != "hell" {
+		t.Fatalf("want %q, got %q", "hell", buf[:n])
+	}
+
+	_, err = conn.(*MemConn).ReadContext(ctx, buf)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want context deadline exceeded, got %v", err)
+	}
+
+	client.Close()
+	_, err = conn.Write([]byte("bye"))
+	if err == nil {
+		t.Fatal("want error writing to closed peer")
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		t.Fatalf("want non timeout error, got %v", err)
+	}
+}
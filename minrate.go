@@ -0,0 +1,115 @@
+package netx
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrTooSlow is returned when a connection is closed for transferring
+// data slower than the MinRate of the listener.
+var ErrTooSlow = errors.New("transfer rate is below the minimum")
+
+// MinRate is a minimum transfer rate: at least Bytes every Period after Grace.
+//
+// Deadlines alone can't catch a peer that trickles data just before they expire
+// (slowloris), connections slower than MinRate are aborted.
+//
+// Only the time spent in Read and Write is counted, so pauses of the application
+// don't make a connection slow. A read window starts with its first byte,
+// connections that send nothing are left to ReadTimeout and IdleTimeout.
+type MinRate struct {
+	Bytes  int
+	Period time.Duration
+	Grace  time.Duration
+}
+
+func (r MinRate) enabled() bool {
+	return r.Bytes > 0 && r.Period > 0
+}
+
+// rateFloor enforces MinRate for a direction of a connection.
+// It's used only by the reading (or writing) goroutine.
+//
+// Windows are measured in the time spent in operations,
+// a lazy window starts with its first byte.
+type rateFloor struct {
+	rate    MinRate
+	lazy    bool
+	window  time.Duration // length of the current window
+	blocked time.Duration // time spent in operations of the current window
+	bytes   int
+	opStart time.Time
+}
+
+func newRateFloor(rate MinRate, lazy bool) *rateFloor {
+	if !rate.enabled() {
+		return nil
+	}
+	return &rateFloor{
+		rate:   rate,
+		lazy:   lazy,
+		window: rate.Grace + rate.Period,
+	}
+}
+
+// begin starts an operation, it returns the time when the connection
+// becomes too slow without new data, zero if never.
+func (f *rateFloor) begin(now time.Time) time.Time {
+	f.opStart = now
+	if f.waiting() {
+		return time.Time{}
+	}
+	remaining := f.window - f.blocked
+	if f.bytes >= f.rate.Bytes {
+		if f.lazy {
+			// the next window waits for its first byte
+			return time.Time{}
+		}
+		remaining += f.rate.Period
+	}
+	return now.Add(remaining)
+}
+
+// done ends the operation with n transferred bytes, it returns false if the rate is too slow.
+func (f *rateFloor) done(now time.Time, n int) bool {
+	if f.waiting() {
+		f.bytes = n
+		return true
+	}
+
+	f.blocked += now.Sub(f.opStart)
+	f.bytes += n
+	for f.blocked >= f.window {
+		if f.bytes < f.rate.Bytes {
+			return false
+		}
+		f.blocked -= f.window
+		f.bytes = 0
+		f.window = f.rate.Period
+		if f.lazy {
+			f.blocked = 0
+			break
+		}
+	}
+	return true
+}
+
+// waiting reports whether a lazy window waits for its first byte.
+func (f *rateFloor) waiting() bool {
+	return f.lazy && f.bytes == 0 && f.blocked == 0
+}
+
+// ioDeadline starts an operation of the floor and returns the earliest
+// of the timeout and the floor deadlines, zero if none.
+func ioDeadline(now time.Time, timeout time.Duration, floor *rateFloor) time.Time {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = now.Add(timeout)
+	}
+	if floor != nil {
+		if fd := floor.begin(now); !fd.IsZero() && (deadline.IsZero() || fd.Before(deadline)) {
+			deadline = fd
+		}
+	}
+	return deadline
+}
@@ -0,0 +1,514 @@
+package netx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Matcher reports whether the connection must be routed to a Mux listener.
+// It reads the first bytes of the connection from r, every matcher reads from the beginning.
+type Matcher func(r io.Reader) bool
+
+// MuxConfig is a config for Mux.
+type MuxConfig struct {
+	// SniffTimeout is the max time to read the bytes needed by matchers (default 1s).
+	// Connections that send nothing in time are routed to the fallback listener.
+	SniffTimeout time.Duration
+
+	// MaxSniffSize is the max number of bytes matchers can read (default 16KB + 5, a TLS record).
+	MaxSniffSize int
+}
+
+// Mux routes connections of a single TCPListener to child listeners
+// by the first bytes of the connection.
+type Mux struct {
+	ln       *TCPListener
+	cfg      MuxConfig
+	routes   []muxRoute
+	fallback *MuxListener
+	doneCh   chan struct{}
+	doneOnce sync.Once
+}
+
+type muxRoute struct {
+	matchers []Matcher
+	ln       *MuxListener
+}
+
+// ErrMuxClosed is returned by Accept of MuxListener when it or its Mux is closed.
+var ErrMuxClosed = errors.New("mux: listener closed")
+
+var errSniffLimit = errors.New("mux: sniff limit reached")
+
+// NewMux returns new Mux over the listener.
+// Call Match and Fallback to create child listeners, then Serve.
+// Serve fails if the listener uses io_uring.
+func NewMux(ln *TCPListener, cfg MuxConfig) *Mux {
+	if cfg.SniffTimeout <= 0 {
+		cfg.SniffTimeout = time.Second
+	}
+	if cfg.MaxSniffSize <= 0 {
+		cfg.MaxSniffSize = 16*1024 + 5
+	}
+	return &Mux{
+		ln:     ln,
+		cfg:    cfg,
+		doneCh: make(chan struct{}),
+	}
+}
+
+// Match returns a listener for connections that satisfy any of the matchers.
+// Matchers are tried in the order of Match calls.
+func (m *Mux) Match(matchers ...Matcher) *MuxListener {
+	ln := m.newListener()
+	m.routes = append(m.routes, muxRoute{matchers: matchers, ln: ln})
+	return ln
+}
+
+// Fallback returns a listener for connections that don't satisfy any matcher.
+// Without fallback such connections are closed.
+func (m *Mux) Fallback() *MuxListener {
+	if m.fallback == nil {
+		m.fallback = m.newListener()
+	}
+	return m.fallback
+}
+
+// Serve accepts connections and routes them to child listeners.
+// It returns when the underlying listener fails or Close is called.
+func (m *Mux) Serve() error {
+	defer m.Close()
+
+	if !m.ln.acceptsConn() {
+		return errNotConn
+	}
+	for {
+		conn, err := m.ln.accept()
+		if err != nil {
+			select {
+			case <-m.doneCh:
+				return nil
+			default:
+				return err
+			}
+		}
+		c, ok := conn.(*Conn)
+		if !ok {
+			conn.Close()
+			return errNotConn
+		}
+		go m.route(c)
+	}
+}
+
+// Close closes the underlying listener and all child listeners.
+func (m *Mux) Close() error {
+	var err error
+	m.doneOnce.Do(func() {
+		close(m.doneCh)
+		err = m.ln.Close()
+	})
+	return err
+}
+
+func (m *Mux) newListener() *MuxListener {
+	return &MuxListener{
+		mux:    m,
+		connCh: make(chan *MuxConn),
+		doneCh: make(chan struct{}),
+		stats:  &Stats{},
+	}
+}
+
+func (m *Mux) route(conn *Conn) {
+	sr := &sniffReader{
+		conn: conn,
+		buf:  DefaultBufferPool.Get(m.cfg.MaxSniffSize)[:0],
+		max:  m.cfg.MaxSniffSize,
+	}
+
+	conn.SetReadDeadline(time.Now().Add(m.cfg.SniffTimeout))
+	ln := m.match(sr)
+	conn.SetReadDeadline(time.Time{})
+
+	if ln == nil {
+		conn.Close()
+		DefaultBufferPool.Put(sr.buf)
+		return
+	}
+	mc := &MuxConn{Conn: conn, buf: sr.buf, sniffed: sr.buf}
+	mc.replayed(0)
+	ln.deliver(mc)
+}
+
+func (m *Mux) match(sr *sniffReader) *MuxListener {
+	for _, route := range m.routes {
+		for _, match := range route.matchers {
+			sr.pos = 0
+			if match(sr) {
+				return route.ln
+			}
+		}
+	}
+
+	var ne net.Error
+	if sr.err == nil || sr.err == io.EOF || sr.err == errSniffLimit || (errors.As(sr.err, &ne) && ne.Timeout()) {
+		return m.fallback
+	}
+	return nil
+}
+
+// MuxListener is a child listener of Mux.
+//
+// Stats of the connections routed to the listener are gathered in its Stats,
+// the sniffed bytes are counted in the Stats of the underlying TCPListener.
+type MuxListener struct {
+	mux       *Mux
+	connCh    chan *MuxConn
+	doneCh    chan struct{}
+	closeOnce sync.Once
+	stats     *Stats
+}
+
+// Accept waits for and returns the next connection routed to the listener.
+func (ln *MuxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.connCh:
+		return conn, nil
+	case <-ln.doneCh:
+		return nil, ErrMuxClosed
+	case <-ln.mux.doneCh:
+		return nil, ErrMuxClosed
+	}
+}
+
+// Close closes the listener, connections routed to it are closed.
+func (ln *MuxListener) Close() error {
+	ln.closeOnce.Do(func() {
+		close(ln.doneCh)
+	})
+	return nil
+}
+
+// Addr returns the address of the underlying listener.
+func (ln *MuxListener) Addr() net.Addr {
+	return ln.mux.ln.Addr()
+}
+
+// Stats of the listener and routed connections.
+func (ln *MuxListener) Stats() *Stats {
+	return ln.stats
+}
+
+func (ln *MuxListener) deliver(conn *MuxConn) {
+	// the conn is not shared yet, the idle wheel gets it after the stats are set
+	conn.Conn.stats = ln.stats
+	if conn.Conn.idle != nil {
+		conn.Conn.idle.add(conn.Conn)
+	}
+	ln.stats.acceptsInc()
+	ln.stats.activeConnsInc()
+
+	select {
+	case ln.connCh <- conn:
+	case <-ln.doneCh:
+		conn.Close()
+	case <-ln.mux.doneCh:
+		conn.Close()
+	}
+}
+
+// MuxConn is a connection routed by Mux, sniffed bytes are replayed on read.
+type MuxConn struct {
+	*Conn
+	buf     []byte
+	sniffed []byte // pooled, released when buf is replayed
+}
+
+// Read reads data from the connection.
+func (c *MuxConn) Read(p []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(p, c.buf)
+		c.replayed(n)
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// ReadContext does same as Read method but with a context.
+func (c *MuxConn) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if len(c.buf) > 0 {
+		return c.Read(p)
+	}
+	return c.Conn.ReadContext(ctx, p)
+}
+
+// WriteTo implements io.WriterTo.
+func (c *MuxConn) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	if len(c.buf) > 0 {
+		n, err := w.Write(c.buf)
+		c.replayed(n)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	n, err := c.Conn.WriteTo(w)
+	return total + n, err
+}
+
+func (c *MuxConn) replayed(n int) {
+	c.buf = c.buf[n:]
+	if len(c.buf) == 0 && c.sniffed != nil {
+		DefaultBufferPool.Put(c.sniffed)
+		c.buf, c.sniffed = nil, nil
+	}
+}
+
+// sniffReader buffers everything read from the conn so it can be replayed.
+type sniffReader struct {
+	conn *Conn
+	buf  []byte
+	pos  int
+	max  int
+	err  error
+}
+
+func (r *sniffReader) Read(p []byte) (int, error) {
+	if r.pos < len(r.buf) {
+		n := copy(p, r.buf[r.pos:])
+		r.pos += n
+		return n, nil
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(r.buf) >= r.max {
+		r.err = errSniffLimit
+		return 0, r.err
+	}
+
+	if len(p) > r.max-len(r.buf) {
+		p = p[:r.max-len(r.buf)]
+	}
+	// Conn.Read would replace SniffTimeout with the conn read deadline
+	n, err := r.conn.TCPConn.Read(p)
+	r.conn.stats.readBytesAdd(r.conn.shard, int64(n))
+	r.buf = append(r.buf, p[:n]...)
+	r.pos += n
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// MatchAny matches any connection.
+func MatchAny() Matcher {
+	return func(r io.Reader) bool { return true }
+}
+
+// MatchPrefix matches connections that start with any of the prefixes.
+func MatchPrefix(prefixes ...string) Matcher {
+	return func(r io.Reader) bool {
+		var buf []byte
+		for _, prefix := range prefixes {
+			if len(buf) < len(prefix) {
+				more := make([]byte, len(prefix)-len(buf))
+				n, _ := io.ReadFull(r, more)
+				buf = append(buf, more[:n]...)
+			}
+			if bytes.HasPrefix(buf, []byte(prefix)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+var httpMethods = []string{
+	"GET ", "HEAD ", "POST ", "PUT ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE ",
+}
+
+// MatchHTTP1 matches HTTP/1.x requests by the method.
+func MatchHTTP1() Matcher {
+	return MatchPrefix(httpMethods...)
+}
+
+// http2Preface is the HTTP/2 client connection preface.
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// MatchHTTP2 matches HTTP/2 connections with prior knowledge (h2c and gRPC without TLS).
+func MatchHTTP2() Matcher {
+	return MatchPrefix(http2Preface)
+}
+
+// MatchPROXY matches connections starting with PROXY protocol v1 or v2 header.
+func MatchPROXY() Matcher {
+	return MatchPrefix("PROXY ", "\r\n\r\n\x00\r\nQUIT\n")
+}
+
+// MatchTLS matches TLS connections.
+// If serverNames are given the SNI of the ClientHello must be one of them.
+func MatchTLS(serverNames ...string) Matcher {
+	return func(r io.Reader) bool {
+		hello, ok := readClientHello(r)
+		if !ok {
+			return false
+		}
+		return len(serverNames) == 0 || containsString(serverNames, hello.serverName)
+	}
+}
+
+// MatchTLSALPN matches TLS connections that offer any of the ALPN protocols.
+func MatchTLSALPN(protos ...string) Matcher {
+	return func(r io.Reader) bool {
+		hello, ok := readClientHello(r)
+		if !ok {
+			return false
+		}
+		for _, proto := range hello.alpn {
+			if containsString(protos, proto) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type clientHello struct {
+	serverName string
+	alpn       []string
+}
+
+// readClientHello parses a TLS ClientHello that fits in the first record.
+func readClientHello(r io.Reader) (*clientHello, bool) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, false
+	}
+	// handshake record, TLS 1.x
+	if header[0] != 0x16 || header[1] != 3 {
+		return nil, false
+	}
+
+	record := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, false
+	}
+
+	p := helloParser(record)
+	if typ, ok := p.u8(); !ok || typ != 1 { // client_hello
+		return nil, false
+	}
+	p.skip(3 + 2 + 32) // length, client_version, random
+	p.skipPrefixed(1)  // session_id
+	p.skipPrefixed(2)  // cipher_suites
+	p.skipPrefixed(1)  // compression_methods
+
+	if len(p) == 0 {
+		return &clientHello{}, true
+	}
+	exts, ok := p.prefixed(2)
+	if !ok {
+		return nil, false
+	}
+
+	hello := &clientHello{}
+	for len(exts) > 0 {
+		typ, ok1 := exts.u16()
+		data, ok2 := exts.prefixed(2)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+
+		switch typ {
+		case 0: // server_name
+			list, _ := data.prefixed(2)
+			for len(list) > 0 {
+				nameType, _ := list.u8()
+				name, ok := list.prefixed(2)
+				if !ok {
+					break
+				}
+				if nameType == 0 { // host_name
+					hello.serverName = string(name)
+				}
+			}
+		case 16: // application_layer_protocol_negotiation
+			list, _ := data.prefixed(2)
+			for len(list) > 0 {
+				proto, ok := list.prefixed(1)
+				if !ok {
+					break
+				}
+				hello.alpn = append(hello.alpn, string(proto))
+			}
+		}
+	}
+	return hello, true
+}
+
+type helloParser []byte
+
+func (p *helloParser) u8() (uint8, bool) {
+	if len(*p) < 1 {
+		return 0, false
+	}
+	v := (*p)[0]
+	*p = (*p)[1:]
+	return v, true
+}
+
+func (p *helloParser) u16() (uint16, bool) {
+	if len(*p) < 2 {
+		return 0, false
+	}
+	v := uint16((*p)[0])<<8 | uint16((*p)[1])
+	*p = (*p)[2:]
+	return v, true
+}
+
+func (p *helloParser) skip(n int) {
+	if n > len(*p) {
+		n = len(*p)
+	}
+	*p = (*p)[n:]
+}
+
+// prefixed returns the data prefixed with a big-endian length of size bytes.
+func (p *helloParser) prefixed(size int) (helloParser, bool) {
+	if len(*p) < size {
+		*p = nil
+		return nil, false
+	}
+	var n int
+	for _, b := range (*p)[:size] {
+		n = n<<8 | int(b)
+	}
+	if len(*p) < size+n {
+		*p = nil
+		return nil, false
+	}
+	data := (*p)[size : size+n]
+	*p = (*p)[size+n:]
+	return data, true
+}
+
+func (p *helloParser) skipPrefixed(size int) {
+	p.prefixed(size)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
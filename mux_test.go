@@ -0,0 +1,120 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMux(t *testing.T) {
+	ln, err := NewTCPListener(context.Background(), "tcp4", "127.0.0.1:0", TCPListenerConfig{})
+	failIfErr(t, err, "cannot create listener: %s", err)
+
+	mux := NewMux(ln, MuxConfig{SniffTimeout: 100 * time.Millisecond})
+	tlsLn := mux.Match(MatchTLS("example.com"))
+	httpLn := mux.Match(MatchHTTP1(), MatchHTTP2())
+	customLn := mux.Match(MatchPrefix("PING"))
+	fallbackLn := mux.Fallback()
+	defer mux.Close()
+
+	go mux.Serve()
+
+	addr := ln.Addr().String()
+	testMuxRoute(t, httpLn, addr, "GET / HTTP/1.1\r\n\r\n")
+	testMuxRoute(t, httpLn, addr, http2Preface)
+	testMuxRoute(t, customLn, addr, "PING")
+	testMuxRoute(t, fallbackLn, addr, "hello")
+	testMuxRoute(t, fallbackLn, addr, "")
+
+	go func() {
+		conn, err := tls.Dial("tcp4", addr, &tls.Config{ServerName: "example.com"})
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := tlsLn.Accept()
+	failIfErr(t, err, "cannot accept tls: %s", err)
+	conn.Close()
+
+	if got := httpLn.Stats().Accepts(); got != 2 {
+		t.Fatalf("want 2 http accepts, got %d", got)
+	}
+}
+
+func testMuxRoute(t *testing.T, ln net.Listener, addr, req string) {
+	t.Helper()
+
+	client, err := net.Dial("tcp4", addr)
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer client.Close()
+
+	_, err = client.Write([]byte(req))
+	failIfErr(t, err, "cannot write: %s", err)
+	err = client.(*net.TCPConn).CloseWrite()
+	failIfErr(t, err, "cannot close write: %s", err)
+
+	conn, err := ln.Accept()
+	failIfErr(t, err, "cannot accept %q: %s", req, err)
+	defer conn.Close()
+
+	got, err := io.ReadAll(conn)
+	failIfErr(t, err, "cannot read: %s", err)
+
+	if string(got) != req {
+		t.Fatalf("want %q, got %q", req, got)
+	}
+}
+
+func TestMux_SniffTimeout(t *testing.T) {
+	cfg := TCPListenerConfig{ReadTimeout: 5 * time.Second}
+	ln, err := NewTCPListener(context.Background(), "tcp4", "127.0.0.1:0", cfg)
+	failIfErr(t, err, "cannot create listener: %s", err)
+
+	mux := NewMux(ln, MuxConfig{SniffTimeout: 50 * time.Millisecond})
+	mux.Match(MatchPrefix("PING"))
+	fallbackLn := mux.Fallback()
+	defer mux.Close()
+
+	go mux.Serve()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer client.Close()
+
+	start := time.Now()
+	conn, err := fallbackLn.Accept()
+	failIfErr(t, err, "cannot accept: %s", err)
+	conn.Close()
+
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("want routed after SniffTimeout, took %s", took)
+	}
+}
+
+func TestMux_IdleTimeout(t *testing.T) {
+	cfg := TCPListenerConfig{IdleTimeout: 20 * time.Millisecond}
+	ln, err := NewTCPListener(context.Background(), "tcp4", "127.0.0.1:0", cfg)
+	failIfErr(t, err, "cannot create listener: %s", err)
+
+	mux := NewMux(ln, MuxConfig{SniffTimeout: 100 * time.Millisecond})
+	pingLn := mux.Match(MatchPrefix("PING"))
+	defer mux.Close()
+
+	go mux.Serve()
+
+	client, err := net.Dial("tcp4", ln.Addr().String())
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer client.Close()
+	_, err = client.Write([]byte("PING"))
+	failIfErr(t, err, "cannot write: %s", err)
+
+	conn, err := pingLn.Accept()
+	failIfErr(t, err, "cannot accept: %s", err)
+	defer conn.Close()
+
+	io.ReadAll(conn)
+	waitFor(t, func() bool { return pingLn.Stats().IdleCloses() == 1 })
+}
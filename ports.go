@@ -0,0 +1,224 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+var errPortHandedOff = errors.New("netx: port is already handed off")
+
+// ListenFree listens on a free port of the loopback interface picked by the kernel
+// and returns the listener with its port.
+// Network is "tcp", "tcp4" or "tcp6", "tcp" listens on IPv4.
+func ListenFree(ctx context.Context, network string, cfg TCPListenerConfig) (*TCPListener, int, error) {
+	var addr string
+	switch network {
+	case "tcp", "tcp4":
+		network, addr = "tcp4", "127.0.0.1:0"
+	case "tcp6":
+		addr = "[::1]:0"
+	default:
+		return nil, 0, net.UnknownNetworkError(network)
+	}
+
+	ln, err := NewTCPListener(ctx, network, addr, cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	port, err := addrPort(ln.Addr())
+	if err != nil {
+		ln.Close()
+		return nil, 0, err
+	}
+	return ln, port, nil
+}
+
+// PortReservation holds free ports of the IPv4 loopback interface
+// with bound sockets, so no one else can take them until they are handed off.
+//
+// The sockets have only SO_REUSEPORT, with SO_REUSEADDR any listener
+// with SO_REUSEADDR could bind a reserved TCP port.
+type PortReservation struct {
+	network string
+
+	mu    sync.Mutex
+	ports []int
+	fds   []int // -1 after a hand off
+}
+
+// ReservePorts reserves n TCP ports.
+func ReservePorts(n int) (*PortReservation, error) {
+	return reservePorts("tcp4", n)
+}
+
+// ReserveUDPPorts reserves n UDP ports.
+func ReserveUDPPorts(n int) (*PortReservation, error) {
+	return reservePorts("udp4", n)
+}
+
+func reservePorts(network string, n int) (*PortReservation, error) {
+	typ, proto := syscall.SOCK_STREAM, syscall.IPPROTO_TCP
+	if network == "udp4" {
+		typ, proto = syscall.SOCK_DGRAM, syscall.IPPROTO_UDP
+	}
+
+	r := &PortReservation{
+		network: network,
+		ports:   make([]int, 0, n),
+		fds:     make([]int, 0, n),
+	}
+	for i := 0; i < n; i++ {
+		fd, port, err := reservePort(typ, proto)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.ports = append(r.ports, port)
+		r.fds = append(r.fds, fd)
+	}
+	return r, nil
+}
+
+func reservePort(typ, proto int) (fd, port int, err error) {
+	fd, err = newSocketCloexec(syscall.AF_INET, typ, proto)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The port is picked by an exclusive bind: the kernel may pick a port
+	// of another socket with SO_REUSEPORT of the same user.
+	if err := newError("bind", syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}})); err != nil {
+		syscall.Close(fd)
+		return 0, 0, fmt.Errorf("cannot reserve port: %s", err)
+	}
+	if err := newError("setsockopt", syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, soReusePort, 1)); err != nil {
+		syscall.Close(fd)
+		return 0, 0, fmt.Errorf("cannot enable SO_REUSEPORT: %s", err)
+	}
+
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		syscall.Close(fd)
+		return 0, 0, newError("getsockname", err)
+	}
+	return fd, sa.(*syscall.SockaddrInet4).Port, nil
+}
+
+// Ports returns the reserved ports.
+func (r *PortReservation) Ports() []int {
+	res := make([]int, len(r.ports))
+	copy(res, r.ports)
+	return res
+}
+
+// Addr returns the address of the i-th port.
+func (r *PortReservation) Addr(i int) string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(r.ports[i]))
+}
+
+// Listen hands off the i-th TCP port to a new TCPListener,
+// cfg.ReusePort is always enabled. The port stays reserved on error.
+func (r *PortReservation) Listen(ctx context.Context, i int, cfg TCPListenerConfig) (*TCPListener, error) {
+	if r.network != "tcp4" {
+		return nil, net.UnknownNetworkError(r.network)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fds[i] == -1 {
+		return nil, errPortHandedOff
+	}
+	cfg.ReusePort = true
+	ln, err := NewTCPListener(ctx, "tcp4", r.Addr(i), cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.release(i)
+	return ln, nil
+}
+
+// ListenPacket hands off the i-th UDP port to a new socket with SO_REUSEPORT.
+// The port stays reserved on error.
+func (r *PortReservation) ListenPacket(ctx context.Context, i int) (net.PacketConn, error) {
+	if r.network != "udp4" {
+		return nil, net.UnknownNetworkError(r.network)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fds[i] == -1 {
+		return nil, errPortHandedOff
+	}
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			errCtrl := c.Control(func(fd uintptr) {
+				err = newError("setsockopt", syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1))
+			})
+			if err == nil {
+				err = errCtrl
+			}
+			return err
+		},
+	}
+	pc, err := lc.ListenPacket(ctx, "udp4", r.Addr(i))
+	if err != nil {
+		return nil, err
+	}
+	r.release(i)
+	return pc, nil
+}
+
+// Release frees the i-th port for a socket without SO_REUSEPORT,
+// prefer Listen or ListenPacket that hand off the port without a gap.
+func (r *PortReservation) Release(i int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fds[i] == -1 {
+		return errPortHandedOff
+	}
+	return r.release(i)
+}
+
+// Close frees the ports that are not handed off.
+func (r *PortReservation) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	for i := range r.fds {
+		if r.fds[i] == -1 {
+			continue
+		}
+		if errClose := r.release(i); err == nil {
+			err = errClose
+		}
+	}
+	return err
+}
+
+// release closes the socket of the i-th port, r.mu must be held.
+func (r *PortReservation) release(i int) error {
+	err := syscall.Close(r.fds[i])
+	r.fds[i] = -1
+	return newError("close", err)
+}
+
+func addrPort(addr net.Addr) (int, error) {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.Port, nil
+	case *net.UDPAddr:
+		return addr.Port, nil
+	}
+	_, port, err := splitHostPort(addr.String())
+	return port, err
+}
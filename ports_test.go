@@ -0,0 +1,103 @@
+package netx
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestListenFree(t *testing.T) {
+	ln, port, err := ListenFree(context.Background(), "tcp", TCPListenerConfig{})
+	failIfErr(t, err, "cannot listen: %s", err)
+	defer ln.Close()
+
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp4", "127.0.0.1:"+strconv.Itoa(port))
+	failIfErr(t, err, "cannot dial: %s", err)
+	conn.Close()
+}
+
+func TestReservePorts(t *testing.T) {
+	r, err := ReservePorts(3)
+	failIfErr(t, err, "cannot reserve: %s", err)
+	defer r.Close()
+
+	ports := r.Ports()
+	if len(ports) != 3 || ports[0] == ports[1] || ports[1] == ports[2] || ports[0] == ports[2] {
+		t.Fatalf("want 3 distinct ports, got %v", ports)
+	}
+
+	if ln, err := net.Listen("tcp4", r.Addr(0)); err == nil {
+		ln.Close()
+		t.Fatal("want reserved port")
+	}
+
+	ln, err := r.Listen(context.Background(), 0, TCPListenerConfig{})
+	failIfErr(t, err, "cannot hand off: %s", err)
+	defer ln.Close()
+	if _, err := r.Listen(context.Background(), 0, TCPListenerConfig{}); err != errPortHandedOff {
+		t.Fatalf("want errPortHandedOff, got %v", err)
+	}
+
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp4", r.Addr(0))
+	failIfErr(t, err, "cannot dial: %s", err)
+	conn.Close()
+
+	err = r.Release(1)
+	failIfErr(t, err, "cannot release: %s", err)
+	ln2, err := net.Listen("tcp4", r.Addr(1))
+	failIfErr(t, err, "cannot listen on released port: %s", err)
+	ln2.Close()
+}
+
+func TestReserveUDPPorts(t *testing.T) {
+	r, err := ReserveUDPPorts(100)
+	failIfErr(t, err, "cannot reserve: %s", err)
+	defer r.Close()
+
+	r2, err := ReserveUDPPorts(100)
+	failIfErr(t, err, "cannot reserve: %s", err)
+	defer r2.Close()
+
+	seen := make(map[int]bool)
+	for _, port := range append(r.Ports(), r2.Ports()...) {
+		if seen[port] {
+			t.Fatalf("port %d is reserved twice", port)
+		}
+		seen[port] = true
+	}
+
+	if pc, err := net.ListenPacket("udp4", r.Addr(0)); err == nil {
+		pc.Close()
+		t.Fatal("want reserved port")
+	}
+
+	pc, err := r.ListenPacket(context.Background(), 0)
+	failIfErr(t, err, "cannot hand off: %s", err)
+	defer pc.Close()
+
+	conn, err := net.Dial("udp4", r.Addr(0))
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	failIfErr(t, err, "cannot write: %s", err)
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	n, _, err := pc.ReadFrom(buf)
+	failIfErr(t, err, "cannot read: %s", err)
+	if string(buf[:n]) != "ping" {
+		t.Fatalf("want ping, got %q", buf[:n])
+	}
+}
@@ -0,0 +1,320 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrIdleTimeout is returned when a proxied connection is idle for too long.
+	ErrIdleTimeout = errors.New("netx: connection is idle")
+
+	// ErrProxyClosed is returned by Proxy after Close.
+	ErrProxyClosed = errors.New("netx: proxy closed")
+
+	errNoUpstream = errors.New("netx: proxy has no upstream")
+)
+
+// ProxyConfig is a config for Proxy.
+type ProxyConfig struct {
+	// Upstream is the address of the upstream.
+	Upstream string
+
+	// Route, if set, chooses the upstream for a client connection instead of Upstream.
+	Route func(conn net.Conn) (string, error)
+
+	// Dial dials upstreams (default Dialer with "tcp" network).
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+
+	// DialTimeout limits the dial of an upstream (default 10s).
+	DialTimeout time.Duration
+
+	// IdleTimeout closes both connections when no data is copied
+	// in either direction for this time, 0 disables it.
+	// Zero-copy is not used when it's set.
+	IdleTimeout time.Duration
+
+	// Timeout limits the whole proxied connection, 0 disables it.
+	Timeout time.Duration
+
+	// ProxyProtocol is the version of PROXY protocol header sent upstream,
+	// 1 or 2, 0 disables it.
+	ProxyProtocol int
+}
+
+// Proxy forwards client connections to an upstream.
+//
+// Data is copied in both directions with zero-copy where possible,
+// a half-close of one side is propagated to the other with CloseWrite.
+// Upstream connections are counted in Stats: written bytes are sent
+// by clients and read bytes are sent by upstreams.
+type Proxy struct {
+	cfg   ProxyConfig
+	stats *Stats
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewProxy returns new Proxy.
+func NewProxy(cfg ProxyConfig) *Proxy {
+	if cfg.Dial == nil {
+		var d Dialer
+		cfg.Dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 10 * time.Second
+	}
+	return &Proxy{
+		cfg:   cfg,
+		stats: &Stats{},
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// Stats of the upstream connections.
+func (p *Proxy) Stats() *Stats {
+	return p.stats
+}
+
+// Serve accepts connections from ln, usually a TCPListener,
+// and proxies each of them in a new goroutine.
+func (p *Proxy) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go p.ServeConn(conn)
+	}
+}
+
+// ServeConn proxies a single client connection and closes it.
+func (p *Proxy) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	if !p.track(conn) {
+		return ErrProxyClosed
+	}
+	defer p.untrack(conn)
+
+	addr := p.cfg.Upstream
+	if p.cfg.Route != nil {
+		var err error
+		if addr, err = p.cfg.Route(conn); err != nil {
+			return err
+		}
+	}
+	if addr == "" {
+		return errNoUpstream
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DialTimeout)
+	upstream, err := p.cfg.Dial(ctx, addr)
+	cancel()
+	if err != nil {
+		return err
+	}
+	upstream = instrumentConn(upstream, p.stats)
+	defer upstream.Close()
+	if !p.track(upstream) {
+		return ErrProxyClosed
+	}
+	defer p.untrack(upstream)
+
+	if p.cfg.ProxyProtocol != 0 {
+		header := appendProxyHeader(nil, p.cfg.ProxyProtocol, conn.RemoteAddr(), conn.LocalAddr())
+		if _, err := upstream.Write(header); err != nil {
+			return err
+		}
+	}
+
+	var deadline time.Time
+	if p.cfg.Timeout > 0 {
+		deadline = time.Now().Add(p.cfg.Timeout)
+	}
+	err = pipe(conn, upstream, p.cfg.IdleTimeout, deadline)
+	if errors.Is(err, ErrIdleTimeout) {
+		p.stats.idleClosesInc()
+	}
+	return err
+}
+
+// Close closes all proxied connections and waits for their goroutines.
+// Listeners passed to Serve are not closed.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	return nil
+}
+
+func (p *Proxy) track(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	p.wg.Add(1)
+	return true
+}
+
+func (p *Proxy) untrack(conn net.Conn) {
+	p.mu.Lock()
+	delete(p.conns, conn)
+	p.mu.Unlock()
+	p.wg.Done()
+}
+
+// pipe copies data between a and b until both directions are done.
+// EOF of a direction is propagated with CloseWrite,
+// an error of a direction closes both connections.
+func pipe(a, b net.Conn, idleTimeout time.Duration, deadline time.Time) error {
+	if idleTimeout <= 0 && !deadline.IsZero() {
+		a.SetDeadline(deadline)
+		b.SetDeadline(deadline)
+	}
+
+	var activeAt int64 // unix nano, accessed atomically
+	errCh := make(chan error, 2)
+	copyFn := func(dst, src net.Conn) {
+		var err error
+		if idleTimeout > 0 {
+			err = copyIdle(dst, src, idleTimeout, deadline, &activeAt)
+		} else {
+			_, err = io.Copy(dst, src)
+		}
+
+		if err != nil {
+			a.Close()
+			b.Close()
+		} else {
+			closeWrite(dst)
+		}
+		errCh <- err
+	}
+
+	go copyFn(b, a)
+	copyFn(a, b)
+
+	err := <-errCh
+	if errOther := <-errCh; err == nil {
+		err = errOther
+	}
+	return err
+}
+
+// copyIdle copies src to dst until EOF or until both directions
+// of the pipe are idle for idleTimeout.
+func copyIdle(dst, src net.Conn, idleTimeout time.Duration, deadline time.Time, activeAt *int64) error {
+	buf := DefaultBufferPool.Get(32 << 10)
+	defer DefaultBufferPool.Put(buf)
+
+	for {
+		now := time.Now()
+		d := now.Add(idleTimeout)
+		if !deadline.IsZero() && deadline.Before(d) {
+			d = deadline
+		}
+		src.SetReadDeadline(d)
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			now = time.Now()
+			atomic.StoreInt64(activeAt, now.UnixNano())
+			dst.SetWriteDeadline(now.Add(idleTimeout))
+			if _, errWrite := dst.Write(buf[:n]); errWrite != nil {
+				return errWrite
+			}
+		}
+
+		switch {
+		case err == nil:
+		case err == io.EOF:
+			return nil
+		case !os.IsTimeout(err):
+			return err
+		case !deadline.IsZero() && !time.Now().Before(deadline):
+			return err
+		case time.Since(time.Unix(0, atomic.LoadInt64(activeAt))) < idleTimeout:
+			// the other direction is active
+		default:
+			return ErrIdleTimeout
+		}
+	}
+}
+
+// closeWrite shuts down the writing side of conn or closes it.
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return conn.Close()
+}
+
+// proxyV2Signature is the signature of PROXY protocol v2 header.
+const proxyV2Signature = "\r\n\r\n\x00\r\nQUIT\n"
+
+// appendProxyHeader appends PROXY protocol header of the version for a connection from src to dst.
+func appendProxyHeader(b []byte, version int, src, dst net.Addr) []byte {
+	srcAddr, ok1 := src.(*net.TCPAddr)
+	dstAddr, ok2 := dst.(*net.TCPAddr)
+	known := ok1 && ok2
+
+	var srcIP, dstIP net.IP
+	v4 := false
+	if known {
+		srcIP, dstIP = srcAddr.IP.To4(), dstAddr.IP.To4()
+		v4 = srcIP != nil && dstIP != nil
+		if !v4 {
+			srcIP, dstIP = srcAddr.IP.To16(), dstAddr.IP.To16()
+			known = srcIP != nil && dstIP != nil
+		}
+	}
+
+	if version == 1 {
+		if !known {
+			return append(b, "PROXY UNKNOWN\r\n"...)
+		}
+		proto := "TCP6"
+		if v4 {
+			proto = "TCP4"
+		}
+		b = append(b, "PROXY "+proto+" "+srcIP.String()+" "+dstIP.String()+" "...)
+		b = strconv.AppendInt(b, int64(srcAddr.Port), 10)
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, int64(dstAddr.Port), 10)
+		return append(b, "\r\n"...)
+	}
+
+	b = append(b, proxyV2Signature...)
+	switch {
+	case !known:
+		// LOCAL command without addresses
+		return append(b, 0x20, 0x00, 0, 0)
+	case v4:
+		b = append(b, 0x21, 0x11, 0, 12)
+	default:
+		b = append(b, 0x21, 0x21, 0, 36)
+	}
+	b = append(b, srcIP...)
+	b = append(b, dstIP...)
+	return append(b, byte(srcAddr.Port>>8), byte(srcAddr.Port), byte(dstAddr.Port>>8), byte(dstAddr.Port))
+}
@@ -0,0 +1,147 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxy(t *testing.T) {
+	echo := newEchoServer(t)
+	p := NewProxy(ProxyConfig{Upstream: echo})
+	addr := serveTestProxy(t, p)
+
+	conn, err := net.Dial("tcp4", addr)
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer conn.Close()
+
+	msg := strings.Repeat("ping", 1024)
+	_, err = io.WriteString(conn, msg)
+	failIfErr(t, err, "cannot write: %s", err)
+	err = conn.(*net.TCPConn).CloseWrite()
+	failIfErr(t, err, "cannot close write: %s", err)
+
+	// EOF from the client reaches the echo server and comes back
+	got, err := io.ReadAll(conn)
+	failIfErr(t, err, "cannot read: %s", err)
+	if string(got) != msg {
+		t.Fatalf("want %d bytes, got %d", len(msg), len(got))
+	}
+
+	waitFor(t, func() bool { return p.Stats().Conns() == 1 })
+	stats := p.Stats()
+	if stats.WrittenBytes() != uint64(len(msg)) || stats.ReadBytes() != uint64(len(msg)) {
+		t.Fatalf("want %d bytes both ways, got %d and %d", len(msg), stats.WrittenBytes(), stats.ReadBytes())
+	}
+	if stats.CloseWrites() != 1 {
+		t.Fatalf("want 1 close write, got %d", stats.CloseWrites())
+	}
+}
+
+func TestProxy_IdleTimeout(t *testing.T) {
+	echo := newEchoServer(t)
+	p := NewProxy(ProxyConfig{
+		Route: func(conn net.Conn) (string, error) {
+			return echo, nil
+		},
+		IdleTimeout: 50 * time.Millisecond,
+	})
+	addr := serveTestProxy(t, p)
+
+	conn, err := net.Dial("tcp4", addr)
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer conn.Close()
+
+	// activity in one direction keeps the connection
+	for i := 0; i < 4; i++ {
+		_, err := conn.Write([]byte("x"))
+		failIfErr(t, err, "cannot write: %s", err)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(conn)
+	failIfErr(t, err, "want close, got %v", err)
+	if string(got) != "xxxx" {
+		t.Fatalf("want echo, got %q", got)
+	}
+	waitFor(t, func() bool { return p.Stats().IdleCloses() == 1 })
+}
+
+func TestProxy_ProxyProtocol(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		failIfErr(t, err, "cannot listen: %s", err)
+		defer ln.Close()
+
+		p := NewProxy(ProxyConfig{Upstream: ln.Addr().String(), ProxyProtocol: version})
+		addr := serveTestProxy(t, p)
+
+		client, err := net.Dial("tcp4", addr)
+		failIfErr(t, err, "cannot dial: %s", err)
+		defer client.Close()
+
+		upstream, err := ln.Accept()
+		failIfErr(t, err, "cannot accept: %s", err)
+		defer upstream.Close()
+
+		clientAddr := client.LocalAddr().(*net.TCPAddr)
+		if version == 1 {
+			line, err := bufio.NewReader(upstream).ReadString('\n')
+			failIfErr(t, err, "cannot read header: %s", err)
+			if !strings.HasPrefix(line, "PROXY TCP4 127.0.0.1 127.0.0.1 "+strconv.Itoa(clientAddr.Port)+" ") {
+				t.Fatalf("unexpected header %q", line)
+			}
+			continue
+		}
+
+		header := make([]byte, 16+12)
+		_, err = io.ReadFull(upstream, header)
+		failIfErr(t, err, "cannot read header: %s", err)
+		if string(header[:12]) != proxyV2Signature || header[12] != 0x21 || header[13] != 0x11 {
+			t.Fatalf("unexpected header %x", header)
+		}
+		if port := int(header[24])<<8 | int(header[25]); port != clientAddr.Port {
+			t.Fatalf("want source port %d, got %d", clientAddr.Port, port)
+		}
+	}
+}
+
+func TestProxy_Close(t *testing.T) {
+	p := NewProxy(ProxyConfig{Upstream: newEchoServer(t)})
+	addr := serveTestProxy(t, p)
+
+	conn, err := net.Dial("tcp4", addr)
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer conn.Close()
+	testEcho(t, conn)
+
+	p.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("want closed connection")
+	}
+	if err := p.ServeConn(conn); err != ErrProxyClosed {
+		t.Fatalf("want ErrProxyClosed, got %v", err)
+	}
+}
+
+// serveTestProxy serves p on a local TCPListener closed with the test.
+func serveTestProxy(tb testing.TB, p *Proxy) string {
+	tb.Helper()
+
+	ln, err := NewTCPListener(context.Background(), "tcp4", "127.0.0.1:0", TCPListenerConfig{})
+	failIfErr(tb, err, "cannot create listener: %s", err)
+	tb.Cleanup(func() {
+		ln.Close()
+		p.Close()
+	})
+
+	go p.Serve(ln)
+	return ln.Addr().String()
+}
@@ -0,0 +1,143 @@
+package netx
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that limits throughput in bytes per second.
+// Zero value is an unlimited limiter.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns new limiter, see SetLimit.
+func NewRateLimiter(bytesPerSec, burst int) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetLimit(bytesPerSec, burst)
+	return l
+}
+
+// SetLimit changes the limit, it's safe to call while the limiter is in use.
+// Burst is the max number of bytes transferred at once (default 1/10 of bytesPerSec).
+// Zero or negative bytesPerSec removes the limit.
+func (l *RateLimiter) SetLimit(bytesPerSec, burst int) {
+	if bytesPerSec <= 0 {
+		bytesPerSec, burst = 0, 0
+	} else if burst <= 0 {
+		burst = bytesPerSec / 10
+		if burst == 0 {
+			burst = 1
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = float64(bytesPerSec)
+	l.burst = burst
+	if l.tokens > float64(burst) {
+		l.tokens = float64(burst)
+	}
+}
+
+// Limit returns the current limit in bytes per second and the burst, zero means unlimited.
+func (l *RateLimiter) Limit() (bytesPerSec, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.rate), l.burst
+}
+
+// wait takes n tokens and waits until the bucket is not in debt.
+// On ctx cancellation the tokens are returned.
+func (l *RateLimiter) wait(ctx context.Context, n int) (time.Duration, error) {
+	d := l.reserve(n)
+	if d <= 0 {
+		return 0, nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	start := time.Now()
+	select {
+	case <-t.C:
+		return d, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return time.Since(start), ctx.Err()
+	}
+}
+
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	} else {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// chunk returns max bytes that can be transferred at once, n if unlimited.
+func (l *RateLimiter) chunk(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate > 0 && l.burst < n {
+		return l.burst
+	}
+	return n
+}
+
+func (l *RateLimiter) limited() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate > 0
+}
+
+// rateLimiters are the per-connection and the shared listener limiters of a direction.
+type rateLimiters struct {
+	conn     *RateLimiter
+	listener *RateLimiter
+}
+
+func (ls rateLimiters) limited() bool {
+	return ls.conn.limited() || ls.listener.limited()
+}
+
+func (ls rateLimiters) chunk(n int) int {
+	return ls.listener.chunk(ls.conn.chunk(n))
+}
+
+func (ls rateLimiters) wait(ctx context.Context, n int) (time.Duration, error) {
+	d1, err := ls.conn.wait(ctx, n)
+	if err != nil {
+		return d1, err
+	}
+	d2, err := ls.listener.wait(ctx, n)
+	return d1 + d2, err
+}
@@ -0,0 +1,264 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ResolverConfig is a config for Resolver.
+type ResolverConfig struct {
+	// Lookup looks up IP addresses of a host (default net.DefaultResolver.LookupIPAddr).
+	Lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	// TTL is how long addresses are fresh (default 30s).
+	TTL time.Duration
+
+	// StaleTTL is how long expired addresses are served
+	// while being refreshed in the background (default 1m).
+	StaleTTL time.Duration
+
+	// NegativeTTL is how long not found hosts are cached (default 5s).
+	NegativeTTL time.Duration
+
+	// LookupTimeout limits a single lookup (default 5s).
+	LookupTimeout time.Duration
+}
+
+// Resolver is a caching DNS resolver.
+//
+// Concurrent lookups of the same host are coalesced into one
+// and the order of returned addresses is randomized.
+type Resolver struct {
+	cfg ResolverConfig
+
+	mu      sync.Mutex
+	entries map[string]*resolverEntry
+	calls   map[string]*resolverCall
+
+	hits      atomicCounter
+	misses    atomicCounter
+	refreshes atomicCounter
+}
+
+type resolverEntry struct {
+	addrs   []net.IPAddr
+	err     error // not found error of a negative entry
+	expires time.Time
+}
+
+type resolverCall struct {
+	done  chan struct{}
+	addrs []net.IPAddr
+	err   error
+}
+
+// NewResolver returns new Resolver.
+func NewResolver(cfg ResolverConfig) *Resolver {
+	if cfg.Lookup == nil {
+		cfg.Lookup = net.DefaultResolver.LookupIPAddr
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 30 * time.Second
+	}
+	if cfg.StaleTTL <= 0 {
+		cfg.StaleTTL = time.Minute
+	}
+	if cfg.NegativeTTL <= 0 {
+		cfg.NegativeTTL = 5 * time.Second
+	}
+	if cfg.LookupTimeout <= 0 {
+		cfg.LookupTimeout = 5 * time.Second
+	}
+	return &Resolver{
+		cfg:     cfg,
+		entries: make(map[string]*resolverEntry),
+		calls:   make(map[string]*resolverCall),
+	}
+}
+
+// LookupIPAddr looks up host using the cache.
+// The returned slice is owned by the caller.
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	if e, ok := r.entries[host]; ok {
+		switch {
+		case now.Before(e.expires):
+			r.mu.Unlock()
+			atomic.AddUint64(&r.hits.count, 1)
+			if e.err != nil {
+				return nil, e.err
+			}
+			return shuffleAddrs(e.addrs), nil
+
+		case e.err == nil && now.Before(e.expires.Add(r.cfg.StaleTTL)):
+			if _, ok := r.calls[host]; !ok {
+				r.lookup(host)
+				atomic.AddUint64(&r.refreshes.count, 1)
+			}
+			r.mu.Unlock()
+			atomic.AddUint64(&r.hits.count, 1)
+			return shuffleAddrs(e.addrs), nil
+		}
+	}
+	call, ok := r.calls[host]
+	if !ok {
+		call = r.lookup(host)
+	}
+	r.mu.Unlock()
+	atomic.AddUint64(&r.misses.count, 1)
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-call.done:
+		if call.err != nil {
+			return nil, call.err
+		}
+		return shuffleAddrs(call.addrs), nil
+	}
+}
+
+// lookup starts a lookup of host, r.mu must be held.
+func (r *Resolver) lookup(host string) *resolverCall {
+	call := &resolverCall{done: make(chan struct{})}
+	r.calls[host] = call
+
+	go func() {
+		// not bound to a caller, the result is shared by all of them
+		ctx, cancel := context.WithTimeout(context.Background(), r.cfg.LookupTimeout)
+		defer cancel()
+		call.addrs, call.err = r.cfg.Lookup(ctx, host)
+
+		now := time.Now()
+		var dnsErr *net.DNSError
+		r.mu.Lock()
+		switch {
+		case call.err == nil && len(call.addrs) == 0:
+			call.err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			r.entries[host] = &resolverEntry{err: call.err, expires: now.Add(r.cfg.NegativeTTL)}
+		case call.err == nil:
+			r.entries[host] = &resolverEntry{addrs: call.addrs, expires: now.Add(r.cfg.TTL)}
+		case errors.As(call.err, &dnsErr) && dnsErr.IsNotFound:
+			r.entries[host] = &resolverEntry{err: call.err, expires: now.Add(r.cfg.NegativeTTL)}
+		}
+		delete(r.calls, host)
+		r.mu.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+// Hits is the number of lookups served from the cache, including stale and negative entries.
+func (r *Resolver) Hits() uint64 { return atomic.LoadUint64(&r.hits.count) }
+
+// Misses is the number of lookups that waited for a resolution.
+func (r *Resolver) Misses() uint64 { return atomic.LoadUint64(&r.misses.count) }
+
+// Refreshes is the number of background refreshes of stale entries.
+func (r *Resolver) Refreshes() uint64 { return atomic.LoadUint64(&r.refreshes.count) }
+
+func shuffleAddrs(addrs []net.IPAddr) []net.IPAddr {
+	res := make([]net.IPAddr, len(addrs))
+	copy(res, addrs)
+	rand.Shuffle(len(res), func(i, j int) {
+		res[i], res[j] = res[j], res[i]
+	})
+	return res
+}
+
+// dialResolved dials addr resolving its host with r,
+// the addresses are tried in order until one succeeds.
+func dialResolved(ctx context.Context, r *Resolver, d *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || net.ParseIP(host) != nil {
+		// an empty host is the local system for net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+
+	if d.Timeout > 0 {
+		// like net.Dialer, the timeout covers the lookup and all the addresses
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	ips, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	return dialAddrs(ctx, d.DialContext, network, host, port, ips)
+}
+
+// minDialShare is the minimum time of a dial attempt when ctx has a deadline, same as in net.
+const minDialShare = 2 * time.Second
+
+// dialAddrs dials the addresses of host suitable for the network in order until one succeeds.
+// When ctx has a deadline each attempt gets an equal share of the time left,
+// so a blackholed address doesn't take all of it.
+func dialAddrs(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), network, host, port string, ips []net.IPAddr) (net.Conn, error) {
+	suitable := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		switch {
+		case network == "tcp4" || network == "udp4":
+			if ip.IP.To4() == nil {
+				continue
+			}
+		case network == "tcp6" || network == "udp6":
+			if ip.IP.To4() != nil {
+				continue
+			}
+		}
+		suitable = append(suitable, ip)
+	}
+
+	var firstErr error
+	for i, ip := range suitable {
+		hostport := ip.IP.String()
+		if ip.Zone != "" {
+			hostport += "%" + ip.Zone
+		}
+		conn, err := dialShare(ctx, dial, network, net.JoinHostPort(hostport, port), len(suitable)-i)
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = &net.OpError{Op: "dial", Net: network, Err: &net.AddrError{Err: "no suitable address found", Addr: host}}
+	}
+	return nil, firstErr
+}
+
+// dialShare dials addr with 1/left of the time left until the ctx deadline.
+func dialShare(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), network, addr string, left int) (net.Conn, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok || left == 1 {
+		return dial(ctx, network, addr)
+	}
+
+	remaining := time.Until(deadline)
+	share := remaining / time.Duration(left)
+	if share < minDialShare {
+		share = minDialShare
+		if remaining < share {
+			share = remaining
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, share)
+	defer cancel()
+	return dial(ctx, network, addr)
+}
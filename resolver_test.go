@@ -0,0 +1,127 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolver(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	r := NewResolver(ResolverConfig{
+		TTL:         20 * time.Millisecond,
+		NegativeTTL: time.Minute,
+		Lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			if host == "missing.test" {
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+			return []net.IPAddr{{IP: net.IPv4(10, 0, 0, 1)}, {IP: net.IPv4(10, 0, 0, 2)}}, nil
+		},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, err := r.LookupIPAddr(context.Background(), "backend.test")
+			if err != nil || len(addrs) != 2 {
+				t.Errorf("want 2 addrs, got %v %v", addrs, err)
+			}
+		}()
+	}
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if c := atomic.LoadInt32(&calls); c != 1 {
+		t.Fatalf("want 1 lookup, got %d", c)
+	}
+
+	_, err := r.LookupIPAddr(context.Background(), "backend.test")
+	failIfErr(t, err, "cannot lookup: %s", err)
+	if r.Hits() != 1 || r.Misses() != 10 {
+		t.Fatalf("want 1 hit and 10 misses, got %d and %d", r.Hits(), r.Misses())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	_, err = r.LookupIPAddr(context.Background(), "backend.test")
+	failIfErr(t, err, "cannot lookup stale: %s", err)
+	if r.Refreshes() != 1 {
+		t.Fatalf("want 1 refresh, got %d", r.Refreshes())
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.LookupIPAddr(context.Background(), "missing.test"); err == nil {
+			t.Fatal("want not found error")
+		}
+	}
+	for atomic.LoadInt32(&calls) != 3 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDialer_Resolver(t *testing.T) {
+	addr := newEchoServer(t)
+	_, port, _ := net.SplitHostPort(addr)
+
+	r := NewResolver(ResolverConfig{
+		Lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			return []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil
+		},
+	})
+	p, err := NewConnPoolWithConfig(context.Background(), "backend.test:"+port, 2, ConnPoolConfig{Resolver: r})
+	failIfErr(t, err, "cannot create pool: %s", err)
+	defer p.Close()
+
+	if r.Misses() != 1 || r.Hits() != 1 {
+		t.Fatalf("want 1 miss and 1 hit, got %d and %d", r.Misses(), r.Hits())
+	}
+}
+
+func TestDialer_ResolverEmptyHost(t *testing.T) {
+	addr := newEchoServer(t)
+	_, port, _ := net.SplitHostPort(addr)
+
+	r := NewResolver(ResolverConfig{
+		Lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		},
+	})
+	d := &Dialer{Resolver: r}
+	conn, err := d.Dial("tcp4", ":"+port)
+	failIfErr(t, err, "cannot dial the local system: %s", err)
+	conn.Close()
+
+	if r.Misses() != 0 {
+		t.Fatalf("want no lookups, got %d", r.Misses())
+	}
+}
+
+func TestDialAddrs_DeadlineShare(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	defer cancel()
+
+	var shares []time.Duration
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		deadline, _ := ctx.Deadline()
+		shares = append(shares, time.Until(deadline))
+		return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("refused")}
+	}
+	ips := []net.IPAddr{{IP: net.IPv4(10, 0, 0, 1)}, {IP: net.IPv4(10, 0, 0, 2)}, {IP: net.IPv4(10, 0, 0, 3)}}
+	if _, err := dialAddrs(ctx, dial, "tcp", "backend.test", "80", ips); err == nil {
+		t.Fatal("want dial error")
+	}
+
+	if len(shares) != 3 || shares[0] > 4*time.Second || shares[1] > 6*time.Second || shares[2] < 11*time.Second {
+		t.Fatalf("want 1/3, 1/2 and all of the deadline, got %v", shares)
+	}
+}
@@ -0,0 +1,85 @@
+package netx
+
+import (
+	"context"
+	"runtime"
+)
+
+// ReusePortGroupConfig is a config for ReusePortGroup.
+type ReusePortGroupConfig struct {
+	// Listener config for each listener in the group, ReusePort is always enabled.
+	Listener TCPListenerConfig
+
+	// Size of the group (default runtime.NumCPU()).
+	Size int
+
+	// SteerByCPU attaches SO_ATTACH_REUSEPORT_CBPF program that picks
+	// the listener by the CPU handling the packet (Linux only).
+	// Use PinToCPU in the accept loop of the i-th listener to keep
+	// the connection on the same CPU.
+	SteerByCPU bool
+}
+
+// ReusePortGroup is a group of TCPListeners bound to the same addr with SO_REUSEPORT.
+//
+// All listeners share the same Stats.
+type ReusePortGroup struct {
+	listeners []*TCPListener
+	stats     *Stats
+}
+
+// NewReusePortGroup returns new group of TCP listeners for the given addr.
+func NewReusePortGroup(ctx context.Context, network, addr string, cfg ReusePortGroupConfig) (*ReusePortGroup, error) {
+	size := cfg.Size
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+	lnCfg := cfg.Listener
+	lnCfg.ReusePort = true
+
+	g := &ReusePortGroup{
+		listeners: make([]*TCPListener, 0, size),
+		stats:     &Stats{},
+	}
+
+	for i := 0; i < size; i++ {
+		ln, err := newTCPListener(ctx, network, addr, lnCfg, g.stats)
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		g.listeners = append(g.listeners, ln)
+	}
+
+	if cfg.SteerByCPU {
+		err := g.listeners[0].control(func(fd int) error {
+			return attachReusePortCPUSteering(fd, size)
+		})
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// Listeners of the group. With SteerByCPU the i-th listener gets connections handled by CPU i (modulo group size).
+func (g *ReusePortGroup) Listeners() []*TCPListener {
+	return g.listeners
+}
+
+// Stats of all listeners in the group.
+func (g *ReusePortGroup) Stats() *Stats {
+	return g.stats
+}
+
+// Close closes all listeners in the group and returns the first error.
+func (g *ReusePortGroup) Close() error {
+	var err error
+	for _, ln := range g.listeners {
+		if errClose := ln.Close(); errClose != nil && err == nil {
+			err = errClose
+		}
+	}
+	return err
+}
@@ -0,0 +1,13 @@
+package netx
+
+import "unsafe"
+
+// On linux/386 socket calls go through socketcall(2) which isn't wired here.
+
+func getsockopt(fd, level, opt int, val unsafe.Pointer, size *uint32) error {
+	return ErrNotSupported
+}
+
+func setsockopt(fd, level, opt int, val unsafe.Pointer, size uintptr) error {
+	return ErrNotSupported
+}
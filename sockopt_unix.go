@@ -0,0 +1,28 @@
+//go:build (linux && !386) || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package netx
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// getsockopt is a raw getsockopt(2) for options not covered by the syscall package.
+func getsockopt(fd, level, opt int, val unsafe.Pointer, size *uint32) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), uintptr(opt),
+		uintptr(val), uintptr(unsafe.Pointer(size)), 0)
+	if errno != 0 {
+		return newError("getsockopt", errno)
+	}
+	return nil
+}
+
+// setsockopt is a raw setsockopt(2) for options not covered by the syscall package.
+func setsockopt(fd, level, opt int, val unsafe.Pointer, size uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), uintptr(level), uintptr(opt),
+		uintptr(val), size, 0)
+	if errno != 0 {
+		return newError("setsockopt", errno)
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 constants, see RFC 1928 and RFC 1929.
+const (
+	socks5Version       = 5
+	socks5AuthVersion   = 1
+	socks5AuthNone      = 0
+	socks5AuthPassword  = 2
+	socks5AuthNoMethods = 0xff
+
+	socks5Connect   = 1
+	socks5Associate = 3
+
+	socks5IPv4   = 1
+	socks5Domain = 3
+	socks5IPv6   = 4
+)
+
+// SOCKS5 reply codes.
+const (
+	socks5Succeeded        = 0
+	socks5GeneralFailure   = 1
+	socks5RuleDenied       = 2
+	socks5NetUnreachable   = 3
+	socks5HostUnreachable  = 4
+	socks5ConnRefused      = 5
+	socks5TTLExpired       = 6
+	socks5CmdNotSupported  = 7
+	socks5AddrNotSupported = 8
+)
+
+// socks5MaxHeader is the max size of a request or a UDP datagram header.
+const socks5MaxHeader = 3 + 1 + 1 + 255 + 2
+
+// ErrSOCKS5Auth is returned when SOCKS5 authentication fails.
+var ErrSOCKS5Auth = errors.New("netx: socks5 authentication failed")
+
+var errSOCKS5Version = errors.New("netx: socks5 unexpected protocol version")
+
+// SOCKS5Error is a non-success reply of a SOCKS5 server.
+type SOCKS5Error struct {
+	Code byte
+}
+
+func (e *SOCKS5Error) Error() string {
+	var msg string
+	switch e.Code {
+	case socks5GeneralFailure:
+		msg = "general failure"
+	case socks5RuleDenied:
+		msg = "connection not allowed by ruleset"
+	case socks5NetUnreachable:
+		msg = "network unreachable"
+	case socks5HostUnreachable:
+		msg = "host unreachable"
+	case socks5ConnRefused:
+		msg = "connection refused"
+	case socks5TTLExpired:
+		msg = "TTL expired"
+	case socks5CmdNotSupported:
+		msg = "command not supported"
+	case socks5AddrNotSupported:
+		msg = "address type not supported"
+	default:
+		msg = "unknown reply " + strconv.Itoa(int(e.Code))
+	}
+	return "netx: socks5 " + msg
+}
+
+// SOCKS5DialerConfig is a config for SOCKS5Dialer.
+type SOCKS5DialerConfig struct {
+	// Username and Password enable username/password authentication.
+	Username string
+	Password string
+
+	// Dial dials the proxy (default Dialer with "tcp" network).
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// SOCKS5Dialer dials connections through a SOCKS5 proxy.
+//
+// TCP networks use CONNECT and UDP networks use UDP ASSOCIATE.
+// Connections are instrumented with the dialer Stats.
+type SOCKS5Dialer struct {
+	proxyAddr string
+	cfg       SOCKS5DialerConfig
+	stats     *Stats
+}
+
+// NewSOCKS5Dialer returns new dialer through the proxy at proxyAddr.
+func NewSOCKS5Dialer(proxyAddr string, cfg SOCKS5DialerConfig) *SOCKS5Dialer {
+	if cfg.Dial == nil {
+		var d Dialer
+		cfg.Dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	return &SOCKS5Dialer{
+		proxyAddr: proxyAddr,
+		cfg:       cfg,
+		stats:     &Stats{},
+	}
+}
+
+// Stats of the dialed connections.
+func (d *SOCKS5Dialer) Stats() *Stats {
+	return d.stats
+}
+
+// Dial connects to addr through the proxy.
+func (d *SOCKS5Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr through the proxy using the provided context.
+// The context bounds the proxy handshake only.
+func (d *SOCKS5Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var cmd byte
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		cmd = socks5Connect
+	case "udp", "udp4", "udp6":
+		cmd = socks5Associate
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	conn, err := d.cfg.Dial(ctx, d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	stopCh := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(aLongTimeAgo)
+		case <-stopCh:
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var bound *socks5Addr
+	if cmd == socks5Connect {
+		bound, err = d.handshake(conn, cmd, host, port)
+	} else {
+		// the client address is not known before the association
+		bound, err = d.handshake(conn, cmd, "0.0.0.0", 0)
+	}
+	close(stopCh)
+	conn.SetDeadline(time.Time{})
+
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: &hostAddr{network: network, host: host, port: port}, Err: err}
+	}
+
+	if cmd == socks5Connect {
+		return instrumentConn(conn, d.stats), nil
+	}
+
+	uc, err := d.associate(conn, bound, host, port)
+	if err != nil {
+		conn.Close()
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: &hostAddr{network: network, host: host, port: port}, Err: err}
+	}
+	return uc, nil
+}
+
+func (d *SOCKS5Dialer) handshake(conn net.Conn, cmd byte, host string, port int) (*socks5Addr, error) {
+	buf := make([]byte, 0, socks5MaxHeader)
+	if d.cfg.Username != "" {
+		buf = append(buf, socks5Version, 2, socks5AuthNone, socks5AuthPassword)
+	} else {
+		buf = append(buf, socks5Version, 1, socks5AuthNone)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	buf = buf[:2]
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] != socks5Version {
+		return nil, errSOCKS5Version
+	}
+
+	switch buf[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if d.cfg.Username == "" || len(d.cfg.Username) > 255 || len(d.cfg.Password) > 255 {
+			return nil, ErrSOCKS5Auth
+		}
+		buf = append(buf[:0], socks5AuthVersion, byte(len(d.cfg.Username)))
+		buf = append(buf, d.cfg.Username...)
+		buf = append(buf, byte(len(d.cfg.Password)))
+		buf = append(buf, d.cfg.Password...)
+		if _, err := conn.Write(buf); err != nil {
+			return nil, err
+		}
+		buf = buf[:2]
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+		if buf[1] != 0 {
+			return nil, ErrSOCKS5Auth
+		}
+	default:
+		return nil, ErrSOCKS5Auth
+	}
+
+	buf = append(buf[:0], socks5Version, cmd, 0)
+	buf, err := appendSOCKS5Addr(buf, host, port)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	buf = buf[:3]
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] != socks5Version {
+		return nil, errSOCKS5Version
+	}
+	if buf[1] != socks5Succeeded {
+		return nil, &SOCKS5Error{Code: buf[1]}
+	}
+	return readSOCKS5Addr(conn)
+}
+
+func (d *SOCKS5Dialer) associate(ctrl net.Conn, bound *socks5Addr, host string, port int) (net.Conn, error) {
+	relay := &net.UDPAddr{IP: net.ParseIP(bound.host), Port: bound.port}
+	if relay.IP == nil || relay.IP.IsUnspecified() {
+		if tcpAddr, ok := ctrl.RemoteAddr().(*net.TCPAddr); ok {
+			relay.IP = tcpAddr.IP
+		}
+	}
+
+	uc, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := appendSOCKS5Addr([]byte{0, 0, 0}, host, port)
+	if err != nil {
+		uc.Close()
+		return nil, err
+	}
+
+	c := &socks5UDPConn{
+		UDPConn: uc,
+		ctrl:    ctrl,
+		header:  header,
+		raddr:   &hostAddr{network: "udp", host: host, port: port},
+		stats:   d.stats,
+		shard:   pickShard(),
+	}
+	// the association ends with the control connection
+	go func() {
+		io.Copy(io.Discard, ctrl)
+		uc.Close()
+	}()
+	return c, nil
+}
+
+// socks5UDPConn is a datagram connection through a SOCKS5 UDP relay.
+type socks5UDPConn struct {
+	*net.UDPConn
+	ctrl   net.Conn
+	header []byte
+	raddr  net.Addr
+	stats  *Stats
+	shard  uint32 // of stats io counters
+}
+
+func (c *socks5UDPConn) Read(b []byte) (int, error) {
+	buf := DefaultBufferPool.Get(len(b) + socks5MaxHeader)
+	defer DefaultBufferPool.Put(buf)
+
+	for {
+		n, err := c.UDPConn.Read(buf)
+		if err != nil {
+			c.stats.readDone(c.shard, 0, err)
+			return 0, err
+		}
+		// fragments are not supported and dropped
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+		_, _, hdrLen, err := parseSOCKS5Addr(buf[3:n])
+		if err != nil {
+			continue
+		}
+		n = copy(b, buf[3+hdrLen:n])
+		c.stats.readDone(c.shard, int64(n), nil)
+		return n, nil
+	}
+}
+
+func (c *socks5UDPConn) Write(b []byte) (int, error) {
+	buf := DefaultBufferPool.Get(len(c.header) + len(b))
+	defer DefaultBufferPool.Put(buf)
+
+	copy(buf, c.header)
+	copy(buf[len(c.header):], b)
+	_, err := c.UDPConn.Write(buf)
+	if err != nil {
+		c.stats.writeDone(c.shard, 0, err)
+		return 0, err
+	}
+	c.stats.writeDone(c.shard, int64(len(b)), nil)
+	return len(b), nil
+}
+
+func (c *socks5UDPConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *socks5UDPConn) Close() error {
+	err := c.UDPConn.Close()
+	if errCtrl := c.ctrl.Close(); err == nil {
+		err = errCtrl
+	}
+	c.stats.connsInc()
+	return err
+}
+
+// socks5Addr is a SOCKS5 address, host is either an IP or a domain name.
+type socks5Addr struct {
+	host string
+	port int
+}
+
+func (a *socks5Addr) Network() string { return "socks5" }
+
+func (a *socks5Addr) String() string {
+	return net.JoinHostPort(a.host, strconv.Itoa(a.port))
+}
+
+func splitHostPort(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xffff {
+		return "", 0, &net.AddrError{Err: "invalid port", Addr: addr}
+	}
+	return host, port, nil
+}
+
+func appendSOCKS5Addr(b []byte, host string, port int) ([]byte, error) {
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		if len(host) == 0 || len(host) > 255 {
+			return nil, &net.AddrError{Err: "invalid host name", Addr: host}
+		}
+		b = append(b, socks5Domain, byte(len(host)))
+		b = append(b, host...)
+	case ip.To4() != nil:
+		b = append(b, socks5IPv4)
+		b = append(b, ip.To4()...)
+	default:
+		b = append(b, socks5IPv6)
+		b = append(b, ip.To16()...)
+	}
+	return append(b, byte(port>>8), byte(port)), nil
+}
+
+func readSOCKS5Addr(r io.Reader) (*socks5Addr, error) {
+	buf := make([]byte, 1+1+255+2)
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return nil, err
+	}
+
+	var n int
+	switch buf[0] {
+	case socks5IPv4:
+		n = 1 + net.IPv4len + 2
+	case socks5IPv6:
+		n = 1 + net.IPv6len + 2
+	case socks5Domain:
+		n = 2 + int(buf[1]) + 2
+	default:
+		return nil, &SOCKS5Error{Code: socks5AddrNotSupported}
+	}
+	if _, err := io.ReadFull(r, buf[2:n]); err != nil {
+		return nil, err
+	}
+
+	host, port, _, err := parseSOCKS5Addr(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	return &socks5Addr{host: host, port: port}, nil
+}
+
+// parseSOCKS5Addr parses an address at the start of b and returns its length.
+func parseSOCKS5Addr(b []byte) (host string, port, n int, err error) {
+	if len(b) < 1 {
+		return "", 0, 0, io.ErrUnexpectedEOF
+	}
+
+	switch b[0] {
+	case socks5IPv4:
+		n = 1 + net.IPv4len
+	case socks5IPv6:
+		n = 1 + net.IPv6len
+	case socks5Domain:
+		if len(b) < 2 {
+			return "", 0, 0, io.ErrUnexpectedEOF
+		}
+		n = 2 + int(b[1])
+	default:
+		return "", 0, 0, &SOCKS5Error{Code: socks5AddrNotSupported}
+	}
+	if len(b) < n+2 {
+		return "", 0, 0, io.ErrUnexpectedEOF
+	}
+
+	if b[0] == socks5Domain {
+		host = string(b[2:n])
+	} else {
+		host = net.IP(b[1:n]).String()
+	}
+	port = int(b[n])<<8 | int(b[n+1])
+	return host, port, n + 2, nil
+}
@@ -0,0 +1,192 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSOCKS5(t *testing.T) {
+	echo := newEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echo)
+	denied := closedAddr(t)
+	_, deniedPort, _ := net.SplitHostPort(denied)
+	port := func(s string) int {
+		_, p, _ := splitHostPort(net.JoinHostPort("h", s))
+		return p
+	}
+
+	proxy := newSOCKS5Server(t, SOCKS5ServerConfig{
+		Auth: func(username, password string) bool {
+			return username == "user" && password == "pass"
+		},
+		Rules: []SOCKS5Rule{
+			{Deny: true, Port: port(deniedPort)},
+			{Host: "localhost"},
+			{Deny: true, Host: ".test"},
+		},
+		Resolver: NewResolver(ResolverConfig{
+			Lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+				return []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil
+			},
+		}),
+	})
+
+	d := NewSOCKS5Dialer(proxy.addr, SOCKS5DialerConfig{Username: "user", Password: "pass"})
+	conn, err := d.Dial("tcp", net.JoinHostPort("localhost", echoPort))
+	failIfErr(t, err, "cannot dial: %s", err)
+
+	msg := []byte("hello")
+	_, err = conn.Write(msg)
+	failIfErr(t, err, "cannot write: %s", err)
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, buf)
+	failIfErr(t, err, "cannot read: %s", err)
+	if string(buf) != string(msg) {
+		t.Fatalf("want %q, got %q", msg, buf)
+	}
+	conn.Close()
+
+	if got := d.Stats().WrittenBytes(); got != uint64(len(msg)) {
+		t.Fatalf("want %d written bytes, got %d", len(msg), got)
+	}
+	dst := net.JoinHostPort("localhost", echoPort)
+	waitFor(t, func() bool {
+		st := proxy.srv.Stats(dst)
+		return st != nil && st.ReadBytes() == uint64(len(msg))
+	})
+
+	_, err = d.Dial("tcp", denied)
+	var socksErr *SOCKS5Error
+	if !errors.As(err, &socksErr) || socksErr.Code != socks5RuleDenied {
+		t.Fatalf("want ruleset error, got %v", err)
+	}
+	_, err = d.Dial("tcp", "backend.test:80")
+	if !errors.As(err, &socksErr) || socksErr.Code != socks5RuleDenied {
+		t.Fatalf("want ruleset error, got %v", err)
+	}
+	if proxy.srv.Denied() != 2 {
+		t.Fatalf("want 2 denied, got %d", proxy.srv.Denied())
+	}
+
+	bad := NewSOCKS5Dialer(proxy.addr, SOCKS5DialerConfig{Username: "user", Password: "nope"})
+	if _, err := bad.Dial("tcp", echo); !errors.Is(err, ErrSOCKS5Auth) {
+		t.Fatalf("want ErrSOCKS5Auth, got %v", err)
+	}
+}
+
+func TestSOCKS5_DenyNet(t *testing.T) {
+	echo := newEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echo)
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+
+	proxy := newSOCKS5Server(t, SOCKS5ServerConfig{
+		Rules: []SOCKS5Rule{
+			{Deny: true, Net: loopback},
+		},
+		Resolver: NewResolver(ResolverConfig{
+			Lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+				return []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1)}, {IP: net.IPv4(127, 0, 0, 1)}}, nil
+			},
+		}),
+	})
+	d := NewSOCKS5Dialer(proxy.addr, SOCKS5DialerConfig{})
+
+	// the name resolves to the denied network
+	for _, addr := range []string{echo, net.JoinHostPort("rebind.example", echoPort)} {
+		_, err := d.Dial("tcp", addr)
+		var socksErr *SOCKS5Error
+		if !errors.As(err, &socksErr) || socksErr.Code != socks5RuleDenied {
+			t.Fatalf("want ruleset error for %s, got %v", addr, err)
+		}
+	}
+}
+
+func TestSOCKS5_MaxDestinations(t *testing.T) {
+	echo := newEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echo)
+
+	proxy := newSOCKS5Server(t, SOCKS5ServerConfig{MaxDestinations: 1})
+	d := NewSOCKS5Dialer(proxy.addr, SOCKS5DialerConfig{})
+	for _, addr := range []string{echo, net.JoinHostPort("localhost", echoPort)} {
+		conn, err := d.Dial("tcp4", addr)
+		failIfErr(t, err, "cannot dial: %s", err)
+		testEcho(t, conn)
+		conn.Close()
+	}
+
+	waitFor(t, func() bool {
+		st := proxy.srv.Stats("")
+		return st != nil && st.Conns() == 1
+	})
+	if dests := proxy.srv.Destinations(); len(dests) != 2 {
+		t.Fatalf("want a destination and the shared stats, got %v", dests)
+	}
+}
+
+func TestSOCKS5_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	failIfErr(t, err, "cannot listen: %s", err)
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	proxy := newSOCKS5Server(t, SOCKS5ServerConfig{UDP: true})
+	d := NewSOCKS5Dialer(proxy.addr, SOCKS5DialerConfig{})
+	conn, err := d.Dial("udp", pc.LocalAddr().String())
+	failIfErr(t, err, "cannot dial: %s", err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	msg := []byte("ping")
+	_, err = conn.Write(msg)
+	failIfErr(t, err, "cannot write: %s", err)
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	failIfErr(t, err, "cannot read: %s", err)
+	if string(buf[:n]) != string(msg) {
+		t.Fatalf("want %q, got %q", msg, buf[:n])
+	}
+	if st := proxy.srv.Stats(pc.LocalAddr().String()); st == nil || st.WrittenBytes() != uint64(len(msg)) {
+		t.Fatal("want destination stats")
+	}
+}
+
+type testSOCKS5Server struct {
+	srv  *SOCKS5Server
+	addr string
+}
+
+func newSOCKS5Server(tb testing.TB, cfg SOCKS5ServerConfig) *testSOCKS5Server {
+	tb.Helper()
+
+	ln, err := NewTCPListener(context.Background(), "tcp4", "127.0.0.1:0", TCPListenerConfig{})
+	failIfErr(tb, err, "cannot create listener: %s", err)
+	tb.Cleanup(func() { ln.Close() })
+
+	srv := NewSOCKS5Server(cfg)
+	go srv.Serve(ln)
+	return &testSOCKS5Server{srv: srv, addr: ln.Addr().String()}
+}
+
+func waitFor(tb testing.TB, fn func() bool) {
+	tb.Helper()
+	for i := 0; i < 100; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	tb.Fatal("condition is not met")
+}
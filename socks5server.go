@@ -0,0 +1,471 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// SOCKS5Rule allows or denies destinations of SOCKS5Server.
+// Empty fields match any destination.
+type SOCKS5Rule struct {
+	// Deny denies matched destinations instead of allowing them.
+	Deny bool
+
+	// Host matches a domain name destination, "example.com" matches exactly
+	// and ".example.com" matches subdomains.
+	Host string
+
+	// Net matches a destination with an IP in the network,
+	// domain names are resolved and all their IPs are checked.
+	Net *net.IPNet
+
+	// Port matches the destination port.
+	Port int
+}
+
+// match reports whether the rule matches the requested host resolved to ip.
+func (r *SOCKS5Rule) match(host string, ip net.IP, port int) bool {
+	if r.Port != 0 && r.Port != port {
+		return false
+	}
+
+	if r.Net != nil && !r.Net.Contains(ip) {
+		return false
+	}
+	if r.Host != "" {
+		if net.ParseIP(host) != nil {
+			return false
+		}
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		pattern := strings.ToLower(r.Host)
+		if strings.HasPrefix(pattern, ".") {
+			return strings.HasSuffix(host, pattern)
+		}
+		return host == pattern
+	}
+	return true
+}
+
+// SOCKS5ServerConfig is a config for SOCKS5Server.
+type SOCKS5ServerConfig struct {
+	// Auth, if set, requires username/password authentication.
+	Auth func(username, password string) bool
+
+	// Rules are checked in order and the first match wins,
+	// destinations without a match are allowed.
+	// A domain name is allowed only if all its IPs are allowed,
+	// the checked IPs are dialed so DNS can't change them.
+	Rules []SOCKS5Rule
+
+	// Resolver, if set, resolves domain names with a cache.
+	Resolver *Resolver
+
+	// Dial dials destinations by IP (default net.Dialer).
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// MaxDestinations limits the number of destinations with their own Stats (default 1024),
+	// other destinations share Stats("").
+	MaxDestinations int
+
+	// HandshakeTimeout limits the handshake and the dial of a destination (default 10s).
+	HandshakeTimeout time.Duration
+
+	// UDP enables UDP ASSOCIATE.
+	UDP bool
+}
+
+// SOCKS5Server is a SOCKS5 server with CONNECT and optional UDP ASSOCIATE.
+//
+// Destinations, as requested by clients, have their own Stats
+// up to SOCKS5ServerConfig.MaxDestinations.
+type SOCKS5Server struct {
+	cfg SOCKS5ServerConfig
+
+	mu    sync.Mutex
+	stats map[string]*Stats
+
+	authFailures atomicCounter
+	denied       atomicCounter
+}
+
+// NewSOCKS5Server returns new SOCKS5Server.
+func NewSOCKS5Server(cfg SOCKS5ServerConfig) *SOCKS5Server {
+	if cfg.Dial == nil {
+		var d net.Dialer
+		cfg.Dial = d.DialContext
+	}
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = 10 * time.Second
+	}
+	if cfg.MaxDestinations <= 0 {
+		cfg.MaxDestinations = 1024
+	}
+	return &SOCKS5Server{
+		cfg:   cfg,
+		stats: make(map[string]*Stats),
+	}
+}
+
+// Serve accepts connections from ln, usually a TCPListener,
+// and serves each of them in a new goroutine.
+func (s *SOCKS5Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single client connection and closes it.
+func (s *SOCKS5Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(s.cfg.HandshakeTimeout))
+	cmd, dst, err := s.handshake(conn)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case socks5Connect:
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.HandshakeTimeout)
+		defer cancel()
+
+		ips, err := s.lookup(ctx, dst.host)
+		if err != nil {
+			s.reply(conn, socks5ReplyCode(err), nil)
+			return err
+		}
+		if !s.allowed(dst.host, ips, dst.port) {
+			s.reply(conn, socks5RuleDenied, nil)
+			return &SOCKS5Error{Code: socks5RuleDenied}
+		}
+		return s.connect(ctx, conn, dst, ips)
+	case socks5Associate:
+		// datagrams are checked by the rules one by one
+		if s.cfg.UDP {
+			return s.associate(conn)
+		}
+	}
+	s.reply(conn, socks5CmdNotSupported, nil)
+	return &SOCKS5Error{Code: socks5CmdNotSupported}
+}
+
+// Stats of the connections to the destination addr, nil if there were none.
+// Stats("") are shared by destinations over the limit.
+func (s *SOCKS5Server) Stats(addr string) *Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats[addr]
+}
+
+// Destinations returns the destinations that have Stats.
+func (s *SOCKS5Server) Destinations() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]string, 0, len(s.stats))
+	for addr := range s.stats {
+		res = append(res, addr)
+	}
+	return res
+}
+
+// AuthFailures is the number of failed authentications.
+func (s *SOCKS5Server) AuthFailures() uint64 { return atomic.LoadUint64(&s.authFailures.count) }
+
+// Denied is the number of requests denied by the rules.
+func (s *SOCKS5Server) Denied() uint64 { return atomic.LoadUint64(&s.denied.count) }
+
+func (s *SOCKS5Server) handshake(conn net.Conn) (byte, *socks5Addr, error) {
+	buf := make([]byte, 2+255)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return 0, nil, err
+	}
+	if buf[0] != socks5Version {
+		return 0, nil, errSOCKS5Version
+	}
+	methods := buf[2 : 2+int(buf[1])]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return 0, nil, err
+	}
+
+	want := byte(socks5AuthNone)
+	if s.cfg.Auth != nil {
+		want = socks5AuthPassword
+	}
+	method := byte(socks5AuthNoMethods)
+	for _, m := range methods {
+		if m == want {
+			method = want
+			break
+		}
+	}
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return 0, nil, err
+	}
+	if method == socks5AuthNoMethods {
+		atomic.AddUint64(&s.authFailures.count, 1)
+		return 0, nil, ErrSOCKS5Auth
+	}
+
+	if method == socks5AuthPassword {
+		if err := s.authenticate(conn, buf); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if _, err := io.ReadFull(conn, buf[:3]); err != nil {
+		return 0, nil, err
+	}
+	if buf[0] != socks5Version {
+		return 0, nil, errSOCKS5Version
+	}
+	cmd := buf[1]
+
+	dst, err := readSOCKS5Addr(conn)
+	if err != nil {
+		var socksErr *SOCKS5Error
+		if errors.As(err, &socksErr) {
+			s.reply(conn, socksErr.Code, nil)
+		}
+		return 0, nil, err
+	}
+	return cmd, dst, nil
+}
+
+func (s *SOCKS5Server) authenticate(conn net.Conn, buf []byte) error {
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != socks5AuthVersion {
+		return errSOCKS5Version
+	}
+	username := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return err
+	}
+	password := make([]byte, buf[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return err
+	}
+
+	if !s.cfg.Auth(string(username), string(password)) {
+		atomic.AddUint64(&s.authFailures.count, 1)
+		conn.Write([]byte{socks5AuthVersion, 1})
+		return ErrSOCKS5Auth
+	}
+	_, err := conn.Write([]byte{socks5AuthVersion, 0})
+	return err
+}
+
+func (s *SOCKS5Server) connect(ctx context.Context, conn net.Conn, dst *socks5Addr, ips []net.IPAddr) error {
+	target, err := dialAddrs(ctx, s.cfg.Dial, "tcp", dst.host, strconv.Itoa(dst.port), ips)
+	if err != nil {
+		s.reply(conn, socks5ReplyCode(err), nil)
+		return err
+	}
+	target = instrumentConn(target, s.destStats(dst.String()))
+	defer target.Close()
+
+	if err := s.reply(conn, socks5Succeeded, target.LocalAddr()); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return pipe(conn, target, 0, time.Time{})
+}
+
+func (s *SOCKS5Server) associate(conn net.Conn) error {
+	var ip net.IP
+	if tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		ip = tcpAddr.IP
+	}
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	if err != nil {
+		s.reply(conn, socks5GeneralFailure, nil)
+		return err
+	}
+	defer pc.Close()
+
+	if err := s.reply(conn, socks5Succeeded, pc.LocalAddr()); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	// the association ends with the control connection
+	go func() {
+		io.Copy(io.Discard, conn)
+		pc.Close()
+	}()
+
+	var clientIP net.IP
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		clientIP = tcpAddr.IP
+	}
+
+	buf := DefaultBufferPool.Get(64 << 10)
+	defer DefaultBufferPool.Put(buf)
+
+	var client *net.UDPAddr
+	dests := make(map[string]string) // resolved addr -> requested addr
+	shard := pickShard()
+	for {
+		n, from, err := pc.ReadFromUDP(buf[socks5MaxHeader:])
+		if err != nil {
+			return nil
+		}
+		packet := buf[socks5MaxHeader : socks5MaxHeader+n]
+
+		if client == nil && from.IP.Equal(clientIP) {
+			client = from
+		}
+
+		if client != nil && from.IP.Equal(client.IP) && from.Port == client.Port {
+			// fragments are not supported and dropped
+			if n < 4 || packet[2] != 0 {
+				continue
+			}
+			host, port, hdrLen, err := parseSOCKS5Addr(packet[3:])
+			if err != nil {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), s.cfg.HandshakeTimeout)
+			ips, err := s.lookup(ctx, host)
+			cancel()
+			if err != nil || !s.allowed(host, ips, port) {
+				continue
+			}
+			dst := (&socks5Addr{host: host, port: port}).String()
+			raddr := &net.UDPAddr{IP: ips[0].IP, Port: port, Zone: ips[0].Zone}
+			dests[raddr.String()] = dst
+
+			payload := packet[3+hdrLen:]
+			_, err = pc.WriteToUDP(payload, raddr)
+			s.destStats(dst).writeDone(shard, int64(len(payload)), err)
+			continue
+		}
+
+		dst, ok := dests[from.String()]
+		if !ok || client == nil {
+			continue
+		}
+		s.destStats(dst).readDone(shard, int64(n), nil)
+
+		header, _ := appendSOCKS5Addr([]byte{0, 0, 0}, from.IP.String(), from.Port)
+		start := socks5MaxHeader - len(header)
+		copy(buf[start:], header)
+		pc.WriteToUDP(buf[start:socks5MaxHeader+n], client)
+	}
+}
+
+// lookup returns the IPs of the host, an IP host is returned as is.
+func (s *SOCKS5Server) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+
+	var ips []net.IPAddr
+	var err error
+	if s.cfg.Resolver != nil {
+		ips, err = s.cfg.Resolver.LookupIPAddr(ctx, host)
+	} else {
+		ips, err = net.DefaultResolver.LookupIPAddr(ctx, host)
+	}
+	if err == nil && len(ips) == 0 {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, err
+}
+
+// allowed reports whether the rules allow all IPs of the requested host.
+func (s *SOCKS5Server) allowed(host string, ips []net.IPAddr, port int) bool {
+	for _, ip := range ips {
+		if !s.allowedIP(host, ip.IP, port) {
+			atomic.AddUint64(&s.denied.count, 1)
+			return false
+		}
+	}
+	return true
+}
+
+func (s *SOCKS5Server) allowedIP(host string, ip net.IP, port int) bool {
+	for i := range s.cfg.Rules {
+		if s.cfg.Rules[i].match(host, ip, port) {
+			return !s.cfg.Rules[i].Deny
+		}
+	}
+	return true
+}
+
+func (s *SOCKS5Server) reply(conn net.Conn, code byte, bound net.Addr) error {
+	host, port := "0.0.0.0", 0
+	switch addr := bound.(type) {
+	case *net.TCPAddr:
+		host, port = addr.IP.String(), addr.Port
+	case *net.UDPAddr:
+		host, port = addr.IP.String(), addr.Port
+	}
+
+	buf, err := appendSOCKS5Addr([]byte{socks5Version, code, 0}, host, port)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(buf)
+	return err
+}
+
+func (s *SOCKS5Server) destStats(addr string) *Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.stats[addr]
+	if ok {
+		return stats
+	}
+	if len(s.stats) >= s.cfg.MaxDestinations {
+		addr = ""
+		if stats, ok := s.stats[addr]; ok {
+			return stats
+		}
+	}
+	stats = &Stats{}
+	s.stats[addr] = stats
+	return stats
+}
+
+func socks5ReplyCode(err error) byte {
+	var ne net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks5ConnRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socks5NetUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return socks5HostUnreachable
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return socks5TTLExpired
+	case errors.As(err, &ne) && ne.Timeout():
+		return socks5TTLExpired
+	default:
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return socks5HostUnreachable
+		}
+		return socks5GeneralFailure
+	}
+}
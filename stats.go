@@ -1,7 +1,14 @@
 package netx
 
 import (
+	"errors"
+	"io"
+	"math/bits"
+	"net"
+	"runtime"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // atomicCounter is a false sharing safe counter.
@@ -12,6 +19,87 @@ type atomicCounter struct {
 
 type cacheLine [64]byte
 
+// ioCounters are the counters updated on every read and write.
+// They are sharded, so cores don't contend on the same cache line,
+// getters sum the shards. A conn picks its shard once with pickShard.
+type ioCounters struct {
+	once   sync.Once
+	shards []ioShard
+}
+
+// ioShard is a cache line of ioCounters.
+type ioShard struct {
+	counts [4]uint64
+	_      [4]uint64
+}
+
+// Indexes of ioShard.counts.
+const (
+	ioReadCalls = iota
+	ioReadBytes
+	ioWriteCalls
+	ioWrittenBytes
+)
+
+// shardNext is the shard of io counters of the next conn.
+var shardNext uint32
+
+// pickShard returns the shard of io counters for a new conn, conns take shards in turn.
+func pickShard() uint32 {
+	return atomic.AddUint32(&shardNext, 1)
+}
+
+// shard returns the i-th shard, i is masked by the number of shards.
+func (c *ioCounters) shard(i uint32) *ioShard {
+	shards := c.init()
+	return &shards[i&uint32(len(shards)-1)]
+}
+
+// sum of the i-th counter over all shards.
+func (c *ioCounters) sum(i int) uint64 {
+	var res uint64
+	shards := c.init()
+	for j := range shards {
+		res += atomic.LoadUint64(&shards[j].counts[i])
+	}
+	return res
+}
+
+// init allocates a shard per P rounded up to a power of two,
+// GOMAXPROCS changes later are handled by the mask in shard.
+func (c *ioCounters) init() []ioShard {
+	c.once.Do(func() {
+		c.shards = make([]ioShard, 1<<sizeClass(runtime.GOMAXPROCS(0)))
+	})
+	return c.shards
+}
+
+// histogramBuckets is the number of power-of-two buckets in a histogram.
+const histogramBuckets = 32
+
+// histogram is a lock-free histogram with power-of-two buckets.
+// Bucket 0 counts zeros, bucket i counts values in [2^(i-1), 2^i),
+// the last bucket also counts everything above.
+type histogram struct {
+	buckets [histogramBuckets]atomicCounter
+}
+
+func (h *histogram) observe(v uint64) {
+	i := bits.Len64(v)
+	if i >= histogramBuckets {
+		i = histogramBuckets - 1
+	}
+	atomic.AddUint64(&h.buckets[i].count, 1)
+}
+
+func (h *histogram) snapshot() []uint64 {
+	res := make([]uint64, histogramBuckets)
+	for i := range h.buckets {
+		res[i] = atomic.LoadUint64(&h.buckets[i].count)
+	}
+	return res
+}
+
 // Stats object that can be queried to obtain certain metrics and get better observability.
 type Stats struct {
 	_            cacheLine
@@ -20,16 +108,33 @@ type Stats struct {
 	activeConns  atomicCounter
 	conns        atomicCounter
 	closeErrors  atomicCounter
+	closeReads   atomicCounter
+	closeWrites  atomicCounter
+	finCloses    atomicCounter
+	resetCloses  atomicCounter
+	idleCloses   atomicCounter
+	slowCloses   atomicCounter
+
+	io ioCounters
 
-	readCalls    atomicCounter
-	readBytes    atomicCounter
 	readErrors   atomicCounter
 	readTimeouts atomicCounter
+	readThrottle atomicCounter
 
-	writeCalls    atomicCounter
-	writtenBytes  atomicCounter
 	writeErrors   atomicCounter
 	writeTimeouts atomicCounter
+	writeThrottle atomicCounter
+
+	tcpInfoSamples atomicCounter
+	retransmits    atomicCounter
+	rtt            histogram
+	sndCwnd        histogram
+
+	handshakes        atomicCounter
+	handshakeErrors   atomicCounter
+	handshakeResumes  atomicCounter
+	handshakeTime     histogram
+	handshakeFailures sync.Map // reason -> *uint64
 
 	_ cacheLine
 }
@@ -38,33 +143,154 @@ func (s *Stats) Accepts() uint64      { return atomic.LoadUint64(&s.accepts.coun
 func (s *Stats) AcceptErrors() uint64 { return atomic.LoadUint64(&s.acceptErrors.count) }
 func (s *Stats) Conns() uint64        { return atomic.LoadUint64(&s.conns.count) }
 func (s *Stats) CloseErrors() uint64  { return atomic.LoadUint64(&s.closeErrors.count) }
+func (s *Stats) CloseReads() uint64   { return atomic.LoadUint64(&s.closeReads.count) }
+func (s *Stats) CloseWrites() uint64  { return atomic.LoadUint64(&s.closeWrites.count) }
+func (s *Stats) FinCloses() uint64    { return atomic.LoadUint64(&s.finCloses.count) }
+func (s *Stats) ResetCloses() uint64  { return atomic.LoadUint64(&s.resetCloses.count) }
+func (s *Stats) IdleCloses() uint64   { return atomic.LoadUint64(&s.idleCloses.count) }
+func (s *Stats) SlowCloses() uint64   { return atomic.LoadUint64(&s.slowCloses.count) }
 
-func (s *Stats) ReadCalls() uint64    { return atomic.LoadUint64(&s.readCalls.count) }
-func (s *Stats) ReadBytes() uint64    { return atomic.LoadUint64(&s.readBytes.count) }
+func (s *Stats) ReadCalls() uint64    { return s.io.sum(ioReadCalls) }
+func (s *Stats) ReadBytes() uint64    { return s.io.sum(ioReadBytes) }
 func (s *Stats) ReadErrors() uint64   { return atomic.LoadUint64(&s.readErrors.count) }
 func (s *Stats) ReadTimeouts() uint64 { return atomic.LoadUint64(&s.readTimeouts.count) }
 
-func (s *Stats) WriteCalls() uint64    { return atomic.LoadUint64(&s.writeCalls.count) }
-func (s *Stats) WrittenBytes() uint64  { return atomic.LoadUint64(&s.writtenBytes.count) }
+// ReadThrottled is the total time reads waited for the rate limiters.
+func (s *Stats) ReadThrottled() time.Duration {
+	return time.Duration(atomic.LoadUint64(&s.readThrottle.count))
+}
+
+func (s *Stats) WriteCalls() uint64    { return s.io.sum(ioWriteCalls) }
+func (s *Stats) WrittenBytes() uint64  { return s.io.sum(ioWrittenBytes) }
 func (s *Stats) WriteErrors() uint64   { return atomic.LoadUint64(&s.writeErrors.count) }
 func (s *Stats) WriteTimeouts() uint64 { return atomic.LoadUint64(&s.writeTimeouts.count) }
 
+// WriteThrottled is the total time writes waited for the rate limiters.
+func (s *Stats) WriteThrottled() time.Duration {
+	return time.Duration(atomic.LoadUint64(&s.writeThrottle.count))
+}
+
+func (s *Stats) TCPInfoSamples() uint64 { return atomic.LoadUint64(&s.tcpInfoSamples.count) }
+func (s *Stats) Retransmits() uint64    { return atomic.LoadUint64(&s.retransmits.count) }
+
+// RTTHistogram of the connections sampled at close, in microseconds.
+// See TCPListenerConfig.SampleTCPInfo.
+//
+// Bucket 0 counts zeros, bucket i counts values in [2^(i-1), 2^i).
+func (s *Stats) RTTHistogram() []uint64 { return s.rtt.snapshot() }
+
+// SndCwndHistogram of the connections sampled at close, in segments.
+// See TCPListenerConfig.SampleTCPInfo.
+//
+// Bucket 0 counts zeros, bucket i counts values in [2^(i-1), 2^i).
+func (s *Stats) SndCwndHistogram() []uint64 { return s.sndCwnd.snapshot() }
+
+func (s *Stats) Handshakes() uint64       { return atomic.LoadUint64(&s.handshakes.count) }
+func (s *Stats) HandshakeErrors() uint64  { return atomic.LoadUint64(&s.handshakeErrors.count) }
+func (s *Stats) HandshakeResumes() uint64 { return atomic.LoadUint64(&s.handshakeResumes.count) }
+
+// HandshakeTimeHistogram of TLS handshakes, in microseconds.
+//
+// Bucket 0 counts zeros, bucket i counts values in [2^(i-1), 2^i).
+func (s *Stats) HandshakeTimeHistogram() []uint64 { return s.handshakeTime.snapshot() }
+
+// HandshakeFailures of TLS handshakes by reason.
+// Reason is a TLS alert sent by the peer (like "bad certificate"),
+// "timeout", "eof" or "local error".
+func (s *Stats) HandshakeFailures() map[string]uint64 {
+	res := map[string]uint64{}
+	s.handshakeFailures.Range(func(k, v interface{}) bool {
+		res[k.(string)] = atomic.LoadUint64(v.(*uint64))
+		return true
+	})
+	return res
+}
+
 func (s *Stats) acceptsInc()      { atomic.AddUint64(&s.accepts.count, 1) }
 func (s *Stats) acceptErrorsInc() { atomic.AddUint64(&s.acceptErrors.count, 1) }
 func (s *Stats) activeConnsInc()  { atomic.AddUint64(&s.activeConns.count, 1) }
 func (s *Stats) connsInc()        { atomic.AddUint64(&s.conns.count, 1) }
 func (s *Stats) closeErrorsInc()  { atomic.AddUint64(&s.closeErrors.count, 1) }
+func (s *Stats) closeReadsInc()   { atomic.AddUint64(&s.closeReads.count, 1) }
+func (s *Stats) closeWritesInc()  { atomic.AddUint64(&s.closeWrites.count, 1) }
+func (s *Stats) finClosesInc()    { atomic.AddUint64(&s.finCloses.count, 1) }
+func (s *Stats) resetClosesInc()  { atomic.AddUint64(&s.resetCloses.count, 1) }
+func (s *Stats) idleClosesInc()   { atomic.AddUint64(&s.idleCloses.count, 1) }
+func (s *Stats) slowClosesInc()   { atomic.AddUint64(&s.slowCloses.count, 1) }
+
+func (s *Stats) readBytesAdd(shard uint32, n int64) {
+	sh := s.io.shard(shard)
+	atomic.AddUint64(&sh.counts[ioReadCalls], 1)
+	atomic.AddUint64(&sh.counts[ioReadBytes], uint64(n))
+}
 
-func (s *Stats) readBytesAdd(n int) {
-	atomic.AddUint64(&s.readCalls.count, 1)
-	atomic.AddUint64(&s.readBytes.count, uint64(n))
+// readDone counts a read of n bytes that returned err in the shard.
+func (s *Stats) readDone(shard uint32, n int64, err error) {
+	s.readBytesAdd(shard, n)
+	if err != nil && err != io.EOF {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			s.readTimeoutsInc()
+		} else {
+			s.readErrorsInc()
+		}
+	}
 }
+
 func (s *Stats) readTimeoutsInc() { atomic.AddUint64(&s.readTimeouts.count, 1) }
 func (s *Stats) readErrorsInc()   { atomic.AddUint64(&s.readErrors.count, 1) }
 
-func (s *Stats) writtenBytesAdd(n int) {
-	atomic.AddUint64(&s.writeCalls.count, 1)
-	atomic.AddUint64(&s.writtenBytes.count, uint64(n))
+func (s *Stats) readThrottledAdd(d time.Duration) {
+	if d > 0 {
+		atomic.AddUint64(&s.readThrottle.count, uint64(d))
+	}
+}
+
+func (s *Stats) writtenBytesAdd(shard uint32, n int64) {
+	sh := s.io.shard(shard)
+	atomic.AddUint64(&sh.counts[ioWriteCalls], 1)
+	atomic.AddUint64(&sh.counts[ioWrittenBytes], uint64(n))
 }
+
+// writeDone counts a write of n bytes that returned err in the shard.
+func (s *Stats) writeDone(shard uint32, n int64, err error) {
+	s.writtenBytesAdd(shard, n)
+	if err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			s.writeTimeoutsInc()
+		} else {
+			s.writeErrorsInc()
+		}
+	}
+}
+
 func (s *Stats) writeTimeoutsInc() { atomic.AddUint64(&s.writeTimeouts.count, 1) }
 func (s *Stats) writeErrorsInc()   { atomic.AddUint64(&s.writeErrors.count, 1) }
+
+func (s *Stats) writeThrottledAdd(d time.Duration) {
+	if d > 0 {
+		atomic.AddUint64(&s.writeThrottle.count, uint64(d))
+	}
+}
+
+func (s *Stats) tcpInfoObserve(info *TCPInfo) {
+	atomic.AddUint64(&s.tcpInfoSamples.count, 1)
+	atomic.AddUint64(&s.retransmits.count, uint64(info.Retransmits))
+	s.rtt.observe(uint64(info.RTT / time.Microsecond))
+	s.sndCwnd.observe(uint64(info.SndCwnd))
+}
+
+func (s *Stats) handshakeObserve(d time.Duration, resumed bool, reason string) {
+	s.handshakeTime.observe(uint64(d / time.Microsecond))
+	if reason != "" {
+		atomic.AddUint64(&s.handshakeErrors.count, 1)
+		v, _ := s.handshakeFailures.LoadOrStore(reason, new(uint64))
+		atomic.AddUint64(v.(*uint64), 1)
+		return
+	}
+	atomic.AddUint64(&s.handshakes.count, 1)
+	if resumed {
+		atomic.AddUint64(&s.handshakeResumes.count, 1)
+	}
+}
@@ -0,0 +1,109 @@
+package netx
+
+import (
+	"time"
+)
+
+// TCPInfo is a portable subset of the kernel TCP_INFO statistics of a connection.
+//
+// Fields that are not provided by the platform are left zero.
+type TCPInfo struct {
+	State TCPState
+
+	// RTT is the smoothed round-trip time.
+	RTT time.Duration
+	// RTTVar is the round-trip time variance.
+	RTTVar time.Duration
+
+	// SndCwnd is the congestion window in segments (in bytes on Darwin).
+	SndCwnd uint32
+	// Retransmits is the total number of retransmitted segments.
+	Retransmits uint32
+	// Lost is the number of segments considered lost.
+	Lost uint32
+
+	// DeliveryRate is the most recent delivery rate in bytes per second.
+	DeliveryRate uint64
+	// BytesAcked is the number of bytes acknowledged by the peer.
+	BytesAcked uint64
+	// PacingRate is the current pacing rate in bytes per second.
+	PacingRate uint64
+}
+
+// TCPState is a state of the TCP state machine.
+type TCPState uint8
+
+// TCP states, numbered as on Linux.
+const (
+	TCPStateUnknown TCPState = iota
+	TCPStateEstablished
+	TCPStateSynSent
+	TCPStateSynReceived
+	TCPStateFinWait1
+	TCPStateFinWait2
+	TCPStateTimeWait
+	TCPStateClosed
+	TCPStateCloseWait
+	TCPStateLastAck
+	TCPStateListen
+	TCPStateClosing
+)
+
+var tcpStateNames = [...]string{
+	TCPStateUnknown:     "UNKNOWN",
+	TCPStateEstablished: "ESTABLISHED",
+	TCPStateSynSent:     "SYN_SENT",
+	TCPStateSynReceived: "SYN_RECEIVED",
+	TCPStateFinWait1:    "FIN_WAIT_1",
+	TCPStateFinWait2:    "FIN_WAIT_2",
+	TCPStateTimeWait:    "TIME_WAIT",
+	TCPStateClosed:      "CLOSED",
+	TCPStateCloseWait:   "CLOSE_WAIT",
+	TCPStateLastAck:     "LAST_ACK",
+	TCPStateListen:      "LISTEN",
+	TCPStateClosing:     "CLOSING",
+}
+
+func (s TCPState) String() string {
+	if int(s) < len(tcpStateNames) {
+		return tcpStateNames[s]
+	}
+	return tcpStateNames[TCPStateUnknown]
+}
+
+// bsdTCPStates maps BSD and Darwin TCPS_* values to TCPState.
+var bsdTCPStates = [...]TCPState{
+	0:  TCPStateClosed,
+	1:  TCPStateListen,
+	2:  TCPStateSynSent,
+	3:  TCPStateSynReceived,
+	4:  TCPStateEstablished,
+	5:  TCPStateCloseWait,
+	6:  TCPStateFinWait1,
+	7:  TCPStateClosing,
+	8:  TCPStateLastAck,
+	9:  TCPStateFinWait2,
+	10: TCPStateTimeWait,
+}
+
+func bsdTCPState(s uint8) TCPState {
+	if int(s) < len(bsdTCPStates) {
+		return bsdTCPStates[s]
+	}
+	return TCPStateUnknown
+}
+
+// TCPInfo returns TCP_INFO statistics of the connection.
+// It's supported on Linux, FreeBSD and Darwin,
+// ErrNotSupported is returned on other platforms, like OpenBSD and NetBSD.
+func (c *Conn) TCPInfo() (*TCPInfo, error) {
+	var info *TCPInfo
+	var infoErr error
+	err := c.Control(func(fd uintptr) {
+		info, infoErr = getTCPInfo(int(fd))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, infoErr
+}
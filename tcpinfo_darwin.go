@@ -0,0 +1,56 @@
+//go:build darwin
+
+package netx
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const tcpConnectionInfo = 0x106
+
+// darwinTCPConnectionInfo mirrors struct tcp_connection_info from netinet/tcp.h.
+type darwinTCPConnectionInfo struct {
+	State               uint8
+	SndWscale           uint8
+	RcvWscale           uint8
+	_                   uint8
+	Options             uint32
+	Flags               uint32
+	Rto                 uint32
+	Maxseg              uint32
+	SndSsthresh         uint32
+	SndCwnd             uint32
+	SndWnd              uint32
+	SndSbbytes          uint32
+	RcvWnd              uint32
+	Rttcur              uint32
+	Srtt                uint32
+	Rttvar              uint32
+	TFO                 uint32
+	TxPackets           uint64
+	TxBytes             uint64
+	TxRetransmitBytes   uint64
+	RxPackets           uint64
+	RxBytes             uint64
+	RxOutOfOrderBytes   uint64
+	TxRetransmitPackets uint64
+}
+
+func getTCPInfo(fd int) (*TCPInfo, error) {
+	var raw darwinTCPConnectionInfo
+	size := uint32(unsafe.Sizeof(raw))
+	if err := getsockopt(fd, syscall.IPPROTO_TCP, tcpConnectionInfo, unsafe.Pointer(&raw), &size); err != nil {
+		return nil, err
+	}
+
+	info := &TCPInfo{
+		State:       bsdTCPState(raw.State),
+		RTT:         time.Duration(raw.Srtt) * time.Millisecond,
+		RTTVar:      time.Duration(raw.Rttvar) * time.Millisecond,
+		SndCwnd:     raw.SndCwnd,
+		Retransmits: uint32(raw.TxRetransmitPackets),
+	}
+	return info, nil
+}
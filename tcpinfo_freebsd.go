@@ -0,0 +1,58 @@
+//go:build freebsd
+
+package netx
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// freebsdTCPInfo mirrors struct tcp_info from netinet/tcp.h.
+type freebsdTCPInfo struct {
+	State         uint8
+	_             [7]uint8
+	Rto           uint32
+	_             uint32
+	SndMss        uint32
+	RcvMss        uint32
+	_             [11]uint32
+	Rtt           uint32
+	Rttvar        uint32
+	SndSsthresh   uint32
+	SndCwnd       uint32
+	_             [3]uint32
+	RcvSpace      uint32
+	SndWnd        uint32
+	SndBwnd       uint32
+	SndNxt        uint32
+	RcvNxt        uint32
+	ToeTid        uint32
+	SndRexmitpack uint32
+	RcvOoopack    uint32
+	SndZerowin    uint32
+	_             [26]uint32
+}
+
+// struct tcp_info is 236 bytes, any other size fails to compile.
+var (
+	_ [unsafe.Sizeof(freebsdTCPInfo{}) - 236]byte
+	_ [236 - unsafe.Sizeof(freebsdTCPInfo{})]byte
+)
+
+func getTCPInfo(fd int) (*TCPInfo, error) {
+	var raw freebsdTCPInfo
+	size := uint32(unsafe.Sizeof(raw))
+	if err := getsockopt(fd, syscall.IPPROTO_TCP, syscall.TCP_INFO, unsafe.Pointer(&raw), &size); err != nil {
+		return nil, err
+	}
+
+	info := &TCPInfo{
+		State:       bsdTCPState(raw.State),
+		RTT:         time.Duration(raw.Rtt) * time.Microsecond,
+		RTTVar:      time.Duration(raw.Rttvar) * time.Microsecond,
+		SndCwnd:     raw.SndCwnd,
+		Retransmits: raw.SndRexmitpack,
+	}
+	return info, nil
+}
@@ -0,0 +1,78 @@
+//go:build linux
+
+package netx
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// linuxTCPInfo mirrors struct tcp_info from linux/tcp.h.
+// Older kernels fill only a prefix of it, the rest stays zero.
+type linuxTCPInfo struct {
+	State         uint8
+	CaState       uint8
+	Retransmits   uint8
+	Probes        uint8
+	Backoff       uint8
+	Options       uint8
+	Wscale        uint8
+	AppLimited    uint8
+	Rto           uint32
+	Ato           uint32
+	SndMss        uint32
+	RcvMss        uint32
+	Unacked       uint32
+	Sacked        uint32
+	Lost          uint32
+	Retrans       uint32
+	Fackets       uint32
+	LastDataSent  uint32
+	LastAckSent   uint32
+	LastDataRecv  uint32
+	LastAckRecv   uint32
+	Pmtu          uint32
+	RcvSsthresh   uint32
+	Rtt           uint32
+	Rttvar        uint32
+	SndSsthresh   uint32
+	SndCwnd       uint32
+	Advmss        uint32
+	Reordering    uint32
+	RcvRtt        uint32
+	RcvSpace      uint32
+	TotalRetrans  uint32
+	PacingRate    uint64
+	MaxPacingRate uint64
+	BytesAcked    uint64
+	BytesReceived uint64
+	SegsOut       uint32
+	SegsIn        uint32
+	NotsentBytes  uint32
+	MinRtt        uint32
+	DataSegsIn    uint32
+	DataSegsOut   uint32
+	DeliveryRate  uint64
+}
+
+func getTCPInfo(fd int) (*TCPInfo, error) {
+	var raw linuxTCPInfo
+	size := uint32(unsafe.Sizeof(raw))
+	if err := getsockopt(fd, syscall.IPPROTO_TCP, syscall.TCP_INFO, unsafe.Pointer(&raw), &size); err != nil {
+		return nil, err
+	}
+
+	info := &TCPInfo{
+		State:        TCPState(raw.State),
+		RTT:          time.Duration(raw.Rtt) * time.Microsecond,
+		RTTVar:       time.Duration(raw.Rttvar) * time.Microsecond,
+		SndCwnd:      raw.SndCwnd,
+		Retransmits:  raw.TotalRetrans,
+		Lost:         raw.Lost,
+		DeliveryRate: raw.DeliveryRate,
+		BytesAcked:   raw.BytesAcked,
+		PacingRate:   raw.PacingRate,
+	}
+	return info, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !freebsd && !darwin
+
+package netx
+
+// getTCPInfo is not implemented on other platforms, OpenBSD and NetBSD have no TCP_INFO.
+func getTCPInfo(fd int) (*TCPInfo, error) {
+	return nil, ErrNotSupported
+}
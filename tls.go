@@ -0,0 +1,255 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSListener is a TCPListener that serves TLS.
+//
+// Besides TCPListener stats it gathers TLS handshake stats.
+type TLSListener struct {
+	*TCPListener
+	config *tls.Config
+}
+
+// NewTLSListener returns new TLS listener for the given addr.
+//
+// Use CertStore.GetCertificate as tls.Config.GetCertificate
+// for SNI-based certificates and hot-reload.
+func NewTLSListener(ctx context.Context, network, addr string, cfg TCPListenerConfig, config *tls.Config) (*TLSListener, error) {
+	if config == nil || (len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil) {
+		return nil, errors.New("tls config must have a certificate")
+	}
+	if cfg.IOUring {
+		return nil, errNotConn
+	}
+
+	ln, err := NewTCPListener(ctx, network, addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tln := &TLSListener{
+		TCPListener: ln,
+		config:      config,
+	}
+	return tln, nil
+}
+
+// Accept accepts connections from the addr passed to NewTLSListener.
+// Returned connection is a *tls.Conn over *Conn, see tls.Conn.NetConn.
+//
+// The handshake is started in a new goroutine to gather its stats,
+// Read, Write and Handshake of the connection wait for it.
+func (ln *TLSListener) Accept() (net.Conn, error) {
+	conn, err := ln.TCPListener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := conn.(*Conn)
+	if !ok {
+		conn.Close()
+		return nil, errNotConn
+	}
+	tc := tls.Server(c, ln.config)
+	go ln.handshake(tc)
+	return tc, nil
+}
+
+func (ln *TLSListener) handshake(tc *tls.Conn) {
+	start := time.Now()
+	err := tc.Handshake()
+	resumed := err == nil && tc.ConnectionState().DidResume
+	ln.stats.handshakeObserve(time.Since(start), resumed, handshakeFailure(err))
+}
+
+// handshakeFailure returns a reason of the failed handshake, empty for nil err.
+func handshakeFailure(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var ne net.Error
+	var oe *net.OpError
+	switch {
+	case errors.As(err, &oe) && oe.Op == "remote error":
+		return strings.TrimPrefix(oe.Err.Error(), "tls: ")
+	case errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	default:
+		return "local error"
+	}
+}
+
+// CertStore serves TLS certificates by SNI and reloads them from disk.
+type CertStore struct {
+	mu    sync.RWMutex
+	certs map[string]*certEntry // by server name, "" is default
+}
+
+type certEntry struct {
+	certFile string
+	keyFile  string
+	modTime  time.Time
+	cert     *tls.Certificate
+}
+
+// NewCertStore returns empty CertStore.
+func NewCertStore() *CertStore {
+	return &CertStore{
+		certs: map[string]*certEntry{},
+	}
+}
+
+// Add loads the certificate for the serverName.
+// Empty serverName sets the default certificate, "*.example.com" matches subdomains.
+func (s *CertStore) Add(serverName, certFile, keyFile string) error {
+	e := &certEntry{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := e.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.certs[strings.ToLower(serverName)] = e
+	s.mu.Unlock()
+	return nil
+}
+
+// Reload reloads all certificates from disk.
+// Certificates that fail to load are kept as is and the first error is returned.
+func (s *CertStore) Reload() error {
+	return s.reload(true)
+}
+
+// Watch reloads changed certificates every interval until ctx is done.
+func (s *CertStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.reload(false)
+		}
+	}
+}
+
+// GetCertificate returns a certificate for the SNI of hello.
+// To be used as tls.Config.GetCertificate.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if e, ok := s.certs[name]; ok {
+		return e.cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if e, ok := s.certs["*"+name[i:]]; ok {
+			return e.cert, nil
+		}
+	}
+	if e, ok := s.certs[""]; ok {
+		return e.cert, nil
+	}
+	return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+}
+
+func (s *CertStore) reload(force bool) error {
+	s.mu.RLock()
+	entries := make(map[string]*certEntry, len(s.certs))
+	for name, e := range s.certs {
+		entries[name] = e
+	}
+	s.mu.RUnlock()
+
+	var err error
+	for name, e := range entries {
+		changed, errStat := e.changed()
+		if errStat != nil {
+			if err == nil {
+				err = errStat
+			}
+			continue
+		}
+		if !force && !changed {
+			continue
+		}
+
+		fresh := &certEntry{
+			certFile: e.certFile,
+			keyFile:  e.keyFile,
+		}
+		if errLoad := fresh.load(); errLoad != nil {
+			if err == nil {
+				err = errLoad
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		if s.certs[name] == e {
+			s.certs[name] = fresh
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (e *certEntry) load() error {
+	modTime, err := e.modTimeOnDisk()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(e.certFile, e.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load certificate %q: %w", e.certFile, err)
+	}
+	e.cert = &cert
+	e.modTime = modTime
+	return nil
+}
+
+func (e *certEntry) changed() (bool, error) {
+	modTime, err := e.modTimeOnDisk()
+	if err != nil {
+		return false, err
+	}
+	return !modTime.Equal(e.modTime), nil
+}
+
+// modTimeOnDisk returns the latest modification time of the cert and key files.
+func (e *certEntry) modTimeOnDisk() (time.Time, error) {
+	certInfo, err := os.Stat(e.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(e.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
@@ -0,0 +1,165 @@
+package netx
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSListener(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, 1)
+
+	store := NewCertStore()
+	err := store.Add("localhost", certFile, keyFile)
+	failIfErr(t, err, "cannot add certificate: %s", err)
+
+	ln, err := NewTLSListener(context.Background(), "tcp4", "127.0.0.1:0", TCPListenerConfig{}, &tls.Config{
+		GetCertificate: store.GetCertificate,
+	})
+	failIfErr(t, err, "cannot create listener: %s", err)
+	defer ln.Close()
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				if req, err := io.ReadAll(c); err == nil {
+					c.Write(req)
+				}
+			}()
+		}
+	}()
+
+	if serial := dialTestTLS(t, ln.Addr().String(), true); serial != 1 {
+		t.Fatalf("want serial 1, got %d", serial)
+	}
+
+	writeTestCert(t, certFile, keyFile, 2)
+	err = store.Reload()
+	failIfErr(t, err, "cannot reload: %s", err)
+
+	if serial := dialTestTLS(t, ln.Addr().String(), true); serial != 2 {
+		t.Fatalf("want serial 2 after reload, got %d", serial)
+	}
+
+	dialTestTLS(t, ln.Addr().String(), false)
+
+	waitFor(t, func() bool { return ln.Stats().Handshakes() == 2 })
+	stats := ln.Stats()
+	if got := stats.HandshakeFailures()["bad certificate"]; got != 1 {
+		t.Fatalf("want 1 bad certificate failure, got %v", stats.HandshakeFailures())
+	}
+}
+
+func TestTLSListener_HTTP2(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, 1)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	failIfErr(t, err, "cannot load certificate: %s", err)
+
+	ln, err := NewTLSListener(context.Background(), "tcp4", "127.0.0.1:0", TCPListenerConfig{}, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	})
+	failIfErr(t, err, "cannot create listener: %s", err)
+	defer ln.Close()
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}),
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+	}
+	resp, err := client.Get("https://" + ln.Addr().String())
+	failIfErr(t, err, "cannot get: %s", err)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		t.Fatalf("want HTTP/2 with TLS state, got %s %s", resp.Proto, resp.Status)
+	}
+	waitFor(t, func() bool { return ln.Stats().Handshakes() == 1 })
+}
+
+func dialTestTLS(tb testing.TB, addr string, insecure bool) int64 {
+	tb.Helper()
+
+	conn, err := tls.Dial("tcp4", addr, &tls.Config{
+		ServerName:         "localhost",
+		InsecureSkipVerify: insecure,
+	})
+	if !insecure {
+		if err == nil {
+			tb.Fatal("want verification error")
+		}
+		// wait for the server to register the alert
+		time.Sleep(50 * time.Millisecond)
+		return 0
+	}
+	failIfErr(tb, err, "cannot dial: %s", err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	failIfErr(tb, err, "cannot write: %s", err)
+	err = conn.CloseWrite()
+	failIfErr(tb, err, "cannot close write: %s", err)
+
+	buf := make([]byte, 4)
+	_, err = conn.Read(buf)
+	failIfErr(tb, err, "cannot read: %s", err)
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func writeTestCert(tb testing.TB, certFile, keyFile string, serial int64) {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	failIfErr(tb, err, "cannot generate key: %s", err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	failIfErr(tb, err, "cannot create certificate: %s", err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	failIfErr(tb, err, "cannot marshal key: %s", err)
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	failIfErr(tb, err, "cannot write certificate: %s", err)
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	failIfErr(tb, err, "cannot write key: %s", err)
+}
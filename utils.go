@@ -7,10 +7,15 @@ import (
 	"math/rand"
 	"net"
 	"os"
-	"time"
 )
 
+// ErrNotSupported is returned when a feature is not available on the platform.
+var ErrNotSupported = errors.New("not supported on this platform")
+
 // EmptyPort looks for an empty port to listen on local interface.
+//
+// Deprecated: the port may be taken before the caller binds it,
+// use ListenFree or ReservePorts.
 func EmptyPort() (int, error) {
 	for p := 30000 + rand.Intn(1000); p < 60000; p++ {
 		l, err := net.Listen("tcp", fmt.Sprintf(":%d", p))
@@ -22,38 +27,16 @@ func EmptyPort() (int, error) {
 	return 0, errors.New("cannot find available port")
 }
 
-// WaitPort until you port is free.
+// WaitPort waits until the local port accepts TCP connections.
+// See Wait for other conditions.
 func WaitPort(ctx context.Context, port int) error {
 	return WaitAddr(ctx, fmt.Sprintf(":%d", port))
 }
 
-// WaitAddr until you addr is free.
+// WaitAddr waits until addr accepts TCP connections.
+// See Wait for other conditions.
 func WaitAddr(ctx context.Context, addr string) error {
-	errCh := make(chan error, 1)
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				errCh <- fmt.Errorf("cannot connect to %s", addr)
-				return
-			default:
-				c, err := net.Dial("tcp", addr)
-				if err == nil {
-					c.Close()
-					errCh <- nil
-					return
-				}
-				time.Sleep(100 * time.Millisecond)
-			}
-		}
-	}()
-
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err := <-errCh:
-		return err
-	}
+	return Wait(ctx, addr, WaitConfig{})
 }
 
 // newError same as os.NewSyscallError but shorter.
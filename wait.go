@@ -0,0 +1,256 @@
+package netx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// WaitMode is a condition that Wait waits for.
+type WaitMode int
+
+const (
+	// WaitListening waits until the address accepts connections.
+	WaitListening WaitMode = iota
+	// WaitFree waits until the address is free to listen on.
+	WaitFree
+)
+
+func (m WaitMode) String() string {
+	switch m {
+	case WaitListening:
+		return "listening"
+	case WaitFree:
+		return "free"
+	default:
+		return "WaitMode(" + strconv.Itoa(int(m)) + ")"
+	}
+}
+
+// WaitConfig is a config for Wait.
+type WaitConfig struct {
+	// Mode is the condition to wait for (default WaitListening).
+	Mode WaitMode
+
+	// Network is "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6" or "unix" (default "tcp").
+	Network string
+
+	// TLSConfig, if set, requires a successful TLS handshake in WaitListening mode.
+	TLSConfig *tls.Config
+
+	// Probe, if set, checks a connection in WaitListening mode,
+	// like sending PING and expecting PONG.
+	// Without it an UDP address is listening when an empty datagram
+	// is not refused by ICMP port unreachable.
+	Probe func(ctx context.Context, conn net.Conn) error
+
+	// AttemptTimeout limits a single attempt (default 1s).
+	AttemptTimeout time.Duration
+
+	// MinBackoff is the delay after the first failed attempt (default 10ms),
+	// it doubles after every next one up to MaxBackoff (default 1s).
+	// Delays are randomized by up to a half.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// WaitError is returned by Wait when ctx is done before the condition is met.
+type WaitError struct {
+	Addr     string
+	Mode     WaitMode
+	Attempts int
+	Elapsed  time.Duration
+
+	// Err is the error of the last attempt.
+	Err error
+
+	ctxErr error
+}
+
+func (e *WaitError) Error() string {
+	msg := "netx: wait for " + e.Addr + " to be " + e.Mode.String() + ": " + e.ctxErr.Error() +
+		" after " + strconv.Itoa(e.Attempts) + " attempts in " + e.Elapsed.Round(time.Millisecond).String()
+	if e.Err != nil {
+		msg += ", last error: " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *WaitError) Unwrap() error { return e.Err }
+
+// Is matches the context error.
+func (e *WaitError) Is(target error) bool { return target == e.ctxErr }
+
+var errWaitBusy = errors.New("netx: address is in use")
+
+// Wait waits until addr meets the condition of cfg.Mode.
+// Attempts are made in the calling goroutine with exponential backoff and jitter,
+// the returned error is *WaitError.
+func Wait(ctx context.Context, addr string, cfg WaitConfig) error {
+	if cfg.Network == "" {
+		cfg.Network = "tcp"
+	}
+	if cfg.AttemptTimeout <= 0 {
+		cfg.AttemptTimeout = time.Second
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 10 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Second
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+
+	start := time.Now()
+	backoff := cfg.MinBackoff
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	var attempts int
+	var lastErr error
+	for {
+		if ctx.Err() == nil {
+			attempts++
+			attemptCtx, cancel := context.WithTimeout(ctx, cfg.AttemptTimeout)
+			var err error
+			if cfg.Mode == WaitFree {
+				err = waitFreeAttempt(attemptCtx, addr, &cfg)
+			} else {
+				err = waitListeningAttempt(attemptCtx, addr, &cfg)
+			}
+			cancel()
+			if err == nil {
+				return nil
+			}
+			// an attempt cut by ctx keeps the error of the previous one
+			if lastErr == nil || !ctxDone(ctx) {
+				lastErr = err
+			}
+
+			delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+			if backoff *= 2; backoff > cfg.MaxBackoff {
+				backoff = cfg.MaxBackoff
+			}
+			timer.Reset(delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return &WaitError{
+				Addr:     addr,
+				Mode:     cfg.Mode,
+				Attempts: attempts,
+				Elapsed:  time.Since(start),
+				Err:      lastErr,
+				ctxErr:   ctx.Err(),
+			}
+		case <-timer.C:
+		}
+	}
+}
+
+// ctxDone reports whether ctx is done or its deadline is reached,
+// the deadline is noticed by dials before the ctx is done.
+func ctxDone(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+func waitListeningAttempt(ctx context.Context, addr string, cfg *WaitConfig) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, cfg.Network, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if cfg.TLSConfig != nil {
+		tlsCfg := cfg.TLSConfig
+		if tlsCfg.ServerName == "" {
+			tlsCfg = tlsCfg.Clone()
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				tlsCfg.ServerName = host
+			}
+		}
+		tlsConn := tls.Client(conn, tlsCfg)
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		conn = tlsConn
+	}
+
+	switch {
+	case cfg.Probe != nil:
+		return cfg.Probe(ctx, conn)
+	case isUDP(cfg.Network):
+		return probeUDP(conn)
+	default:
+		return nil
+	}
+}
+
+// probeUDP sends an empty datagram, a listening address doesn't refuse it.
+func probeUDP(conn net.Conn) error {
+	if _, err := conn.Write(nil); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := conn.Read(make([]byte, 1))
+	var ne net.Error
+	if err == nil || errors.As(err, &ne) && ne.Timeout() {
+		return nil
+	}
+	return err
+}
+
+func waitFreeAttempt(ctx context.Context, addr string, cfg *WaitConfig) error {
+	var lc net.ListenConfig
+	switch {
+	case cfg.Network == "unix":
+		// binding would create the socket file, so nobody must accept connections
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "unix", addr)
+		if err != nil {
+			if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		conn.Close()
+		return errWaitBusy
+
+	case isUDP(cfg.Network):
+		pc, err := lc.ListenPacket(ctx, cfg.Network, addr)
+		if err != nil {
+			return err
+		}
+		return pc.Close()
+
+	default:
+		ln, err := lc.Listen(ctx, cfg.Network, addr)
+		if err != nil {
+			return err
+		}
+		return ln.Close()
+	}
+}
+
+func isUDP(network string) bool {
+	return network == "udp" || network == "udp4" || network == "udp6"
+}
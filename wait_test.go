@@ -0,0 +1,132 @@
+package netx
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	r, err := ReservePorts(1)
+	failIfErr(t, err, "cannot reserve: %s", err)
+	defer r.Close()
+
+	lnCh := make(chan *TCPListener, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ln, err := r.Listen(context.Background(), 0, TCPListenerConfig{})
+		if err != nil {
+			t.Error(err)
+		}
+		lnCh <- ln
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = Wait(ctx, r.Addr(0), WaitConfig{})
+	failIfErr(t, err, "cannot wait listening: %s", err)
+
+	ln := <-lnCh
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ln.Close()
+	}()
+	err = Wait(ctx, r.Addr(0), WaitConfig{Mode: WaitFree})
+	failIfErr(t, err, "cannot wait free: %s", err)
+}
+
+func TestWait_Error(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := WaitAddr(ctx, closedAddr(t))
+	var waitErr *WaitError
+	if !errors.As(err, &waitErr) {
+		t.Fatalf("want WaitError, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("want deadline and refused errors, got %v", err)
+	}
+	if waitErr.Attempts < 2 {
+		t.Fatalf("want a few attempts, got %d", waitErr.Attempts)
+	}
+}
+
+func TestWait_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	failIfErr(t, err, "cannot listen: %s", err)
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := Wait(ctx, addr, WaitConfig{Network: "udp4"}); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("want refused, got %v", err)
+	}
+
+	pc, err = net.ListenPacket("udp4", addr)
+	failIfErr(t, err, "cannot listen: %s", err)
+	defer pc.Close()
+	err = Wait(context.Background(), addr, WaitConfig{Network: "udp4"})
+	failIfErr(t, err, "cannot wait: %s", err)
+}
+
+func TestWait_Probe(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, 1)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	failIfErr(t, err, "cannot load certificate: %s", err)
+
+	sock := filepath.Join(dir, "pong.sock")
+	ln, err := tls.Listen("unix", sock, &tls.Config{Certificates: []tls.Certificate{cert}})
+	failIfErr(t, err, "cannot listen: %s", err)
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if line, err := bufio.NewReader(conn).ReadString('\n'); err == nil && line == "PING\n" {
+					conn.Write([]byte("PONG\n"))
+				}
+			}()
+		}
+	}()
+
+	cfg := WaitConfig{
+		Network:   "unix",
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		Probe: func(ctx context.Context, conn net.Conn) error {
+			if _, err := conn.Write([]byte("PING\n")); err != nil {
+				return err
+			}
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if line != "PONG\n" {
+				return errors.New("unexpected reply " + line)
+			}
+			return nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = Wait(ctx, sock, cfg)
+	failIfErr(t, err, "cannot wait: %s", err)
+
+	ln.Close()
+	err = Wait(ctx, sock, WaitConfig{Network: "unix", Mode: WaitFree})
+	failIfErr(t, err, "cannot wait free: %s", err)
+}